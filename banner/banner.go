@@ -0,0 +1,177 @@
+// Package banner grabs raw service responses from open ports.
+package banner
+
+import (
+  "bytes"
+  "context"
+  "crypto/tls"
+  "fmt"
+  "io"
+  "log"
+  "net"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// Probes recorded per port.
+const (
+  // Whatever the service sends on connect before we say anything.
+  ProbePassive = "passive"
+  ProbeHTTP = "http"
+  ProbeTLS = "tls"
+  ProbeHTTPS = "https"
+  ProbeSMTP = "smtp"
+  ProbeRedis = "redis"
+)
+
+var tlsVersions = map[uint16]string{
+  tls.VersionTLS10: "TLS 1.0",
+  tls.VersionTLS11: "TLS 1.1",
+  tls.VersionTLS12: "TLS 1.2",
+  tls.VersionTLS13: "TLS 1.3",
+}
+
+// Client holds the db dependency.
+type Client struct {
+  db *storage.Client
+  // Timeout of each connection and read.
+  timeout time.Duration
+  // Maximum number of bytes kept per response.
+  maxBytes int
+}
+
+// New returns a new banner client.
+func New(db *storage.Client, timeout time.Duration, maxBytes int) *Client {
+  return &Client{
+    db: db,
+    timeout: timeout,
+    maxBytes: maxBytes,
+  }
+}
+
+// Grab connects to each open TCP port of the subdomain, records the responses
+// to the probes that apply and stores them.
+func (c *Client) Grab(ctx context.Context, subdomain *storage.Subdomain) error {
+  for _, port := range subdomain.Ports {
+    if port.Protocol != "tcp" {
+      continue
+    }
+    port.Banners = c.grabPort(ctx, port)
+    for _, banner := range port.Banners {
+      if err := c.db.InsertBanner(banner); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+// grabPort runs the probes against a single port.
+func (c *Client) grabPort(ctx context.Context, port *storage.Port) []*storage.Banner {
+  addr := net.JoinHostPort(port.Subdomain, strconv.Itoa(port.Number))
+  banners := []*storage.Banner{}
+  add := func(probe string, response []byte) {
+    if len(response) == 0 {
+      return
+    }
+    banners = append(banners, &storage.Banner{
+      Subdomain: port.Subdomain,
+      Port: port.Number,
+      Protocol: port.Protocol,
+      Probe: probe,
+      Response: string(response),
+    })
+  }
+
+  passive, err := c.exchange(ctx, addr, nil)
+  if err != nil {
+    log.Printf("failed to grab banner of %s: %v", addr, err)
+    return banners
+  }
+  add(ProbePassive, passive)
+
+  switch {
+  case bytes.HasPrefix(passive, []byte("220")) && (strings.Contains(port.Service, "smtp") || port.Service == "submission" || port.Number == 25 || port.Number == 587):
+    res, _ := c.exchange(ctx, addr, []byte("EHLO bounty-hunter.local\r\n"))
+    add(ProbeSMTP, res)
+  case port.Service == "redis" || port.Number == 6379:
+    res, _ := c.exchange(ctx, addr, []byte("*1\r\n$4\r\nPING\r\n"))
+    add(ProbeRedis, res)
+  case len(passive) == 0:
+    // Client-first protocols, most likely HTTP with or without TLS.
+    summary, res := c.tls(ctx, addr, port.Subdomain)
+    if summary != nil {
+      add(ProbeTLS, summary)
+      add(ProbeHTTPS, res)
+    } else {
+      res, _ := c.exchange(ctx, addr, httpRequest(port.Subdomain))
+      add(ProbeHTTP, res)
+    }
+  }
+  return banners
+}
+
+// exchange connects to addr, writes the payload if any and returns the first
+// bytes of the response.
+func (c *Client) exchange(ctx context.Context, addr string, payload []byte) ([]byte, error) {
+  dialer := net.Dialer{Timeout: c.timeout}
+  conn, err := dialer.DialContext(ctx, "tcp", addr)
+  if err != nil {
+    return nil, err
+  }
+  defer conn.Close()
+  if payload == nil {
+    // Server-first protocols send a greeting and wait, so don't wait for more.
+    conn.SetReadDeadline(time.Now().Add(c.timeout))
+    buf := make([]byte, c.maxBytes)
+    n, _ := conn.Read(buf)
+    return buf[:n], nil
+  }
+  conn.SetWriteDeadline(time.Now().Add(c.timeout))
+  if _, err := conn.Write(payload); err != nil {
+    return nil, err
+  }
+  return c.read(conn), nil
+}
+
+// tls performs a TLS handshake with addr and returns a summary of the
+// negotiated session and certificate along with the response to an HTTP
+// request over it. The summary is nil if the port doesn't speak TLS.
+func (c *Client) tls(ctx context.Context, addr, host string) ([]byte, []byte) {
+  dialer := &net.Dialer{Timeout: c.timeout}
+  conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+    ServerName: host,
+    InsecureSkipVerify: true,
+  })
+  if err != nil {
+    return nil, nil
+  }
+  defer conn.Close()
+
+  state := conn.ConnectionState()
+  summary := fmt.Sprintf("version: %s\ncipher: %s\n", tlsVersions[state.Version], tls.CipherSuiteName(state.CipherSuite))
+  if len(state.PeerCertificates) > 0 {
+    cert := state.PeerCertificates[0]
+    summary += fmt.Sprintf("subject: %s\nissuer: %s\nnot after: %s\ndns names: %s\n", cert.Subject, cert.Issuer, cert.NotAfter.Format(time.RFC3339), strings.Join(cert.DNSNames, ", "))
+  }
+  conn.SetWriteDeadline(time.Now().Add(c.timeout))
+  if _, err := conn.Write(httpRequest(host)); err != nil {
+    return []byte(summary), nil
+  }
+  return []byte(summary), c.read(conn)
+}
+
+// read returns up to maxBytes of what the connection sends before the timeout.
+func (c *Client) read(conn net.Conn) []byte {
+  conn.SetReadDeadline(time.Now().Add(c.timeout))
+  buf := make([]byte, c.maxBytes)
+  n, _ := io.ReadFull(conn, buf)
+  return buf[:n]
+}
+
+func httpRequest(host string) []byte {
+  return []byte(fmt.Sprintf("GET / HTTP/1.0\r\nHost: %s\r\nUser-Agent: Mozilla/5.0\r\nAccept: */*\r\n\r\n", host))
+}
@@ -3,33 +3,118 @@ package main
 import (
   "context"
   "bufio"
+  "encoding/json"
   "flag"
   "fmt"
   "log"
   "net"
   "net/http"
+  "os"
+  "os/signal"
   "regexp"
   "strings"
+  "syscall"
+  "time"
 
   "golang.org/x/net/publicsuffix"
   "github.com/CaliDog/certstream-go"
+  "github.com/dlegs/bounty-hunter/banner"
+  "github.com/dlegs/bounty-hunter/httpprobe"
+  "github.com/dlegs/bounty-hunter/bruteforce"
+  "github.com/dlegs/bounty-hunter/cloudip"
   "github.com/dlegs/bounty-hunter/notify"
+  "github.com/dlegs/bounty-hunter/permute"
   "github.com/dlegs/bounty-hunter/portscan"
+  "github.com/dlegs/bounty-hunter/registrable"
+  "github.com/dlegs/bounty-hunter/resolver"
   "github.com/dlegs/bounty-hunter/screenshot"
   "github.com/dlegs/bounty-hunter/storage"
   "github.com/dlegs/bounty-hunter/takeover"
+  "github.com/dlegs/bounty-hunter/tech"
+  "github.com/dlegs/bounty-hunter/zonetransfer"
 )
 
 const (
   targetsURL = "https://raw.githubusercontent.com/arkadiyt/bounty-targets-data/master/data/wildcards.txt"
+  // Per platform program lists, formatted with the platform name.
+  programsURL = "https://raw.githubusercontent.com/arkadiyt/bounty-targets-data/master/data/%s_data.json"
 )
 
+// mobileTarget matches the target types of apps, whose reverse domain package
+// names look like hosts.
+var mobileTarget = regexp.MustCompile(`(?i)android|ios|apple|google_play|apk|mobile`)
+
+// programPlatforms are the bug bounty platforms bounty-targets-data lists
+// programs of.
+var programPlatforms = []string{"hackerone", "bugcrowd", "intigriti", "yeswehack", "federacy"}
+
 var (
   useBountyTargets = flag.Bool("use_bounty_targets", true, "use all available bug bounty targets from https://github.com/arkadiyt/bounty-targets-data")
   targets = flag.String("targets", "", "manually specified targets")
-  fingerprints = flag.String("fingerprints", "fingerprints.json", "JSON file containing subjack fingerprints")
+  fingerprints = flag.String("fingerprints", "fingerprints.json", "JSON file, or directory of JSON files, containing subjack fingerprints, reloaded on SIGHUP")
+  fingerprintsWatch = flag.Duration("fingerprints_watch", time.Minute, "how often to check the fingerprints for changes and reload them, disabled if 0")
+  takeoverTimeout = flag.Duration("takeover_timeout", 10*time.Second, "timeout of each takeover fingerprint request")
+  takeoverHTTPS = flag.Bool("takeover_https", true, "also fetch subdomains over HTTPS when matching takeover fingerprints")
   dbName = flag.String("db_name", "bountyhunter.db", "name of sqlite db file to use")
   slackEnv = flag.String("slack_env", "SLACK_TOKEN", "name of env variable holding slack token")
+  scanner = flag.String("scanner", "nmap", "port scanner to use, either nmap or connect")
+  scanPorts = flag.String("ports", "", "ports scanned by the connect scanner e.g. 22,80,8000-8100, defaults to a list of common ports")
+  scanProfiles = flag.String("scan_profiles", "", "JSON file containing scan profiles, only the built-in profiles are available if empty")
+  scanProfile = flag.String("scan_profile", "", "scan profile used unless a program profile applies, overrides the profiles file default")
+  scanTimeout = flag.Duration("scan_timeout", 2*time.Second, "timeout of a single connect scanner connection")
+  scanWorkers = flag.Int("scan_workers", 100, "number of concurrent connect scanner connections per host")
+  scanRate = flag.Int("scan_rate", 0, "maximum number of connect scanner connections per second per host, unlimited if 0")
+  serviceDetection = flag.Bool("service_detection", false, "run nmap service detection on ports found open by the connect scanner")
+  udp = flag.Bool("udp", false, "also scan UDP ports, nmap must run as root unless the connect scanner is used")
+  udpPorts = flag.String("udp_ports", "", "UDP ports to scan, defaults to DNS, NTP, SNMP, IKE, SSDP and memcached")
+  scanCacheTTL = flag.Duration("scan_cache_ttl", 24*time.Hour, "how long scan results of an IP are reused for other subdomains resolving to it, disabled if 0")
+  cdnPolicy = flag.String("cdn_policy", "web", "how to scan subdomains behind Cloudflare, Akamai, Fastly or CloudFront: scan, web (web ports only) or skip")
+  banners = flag.Bool("banners", true, "record raw responses of open ports to passive, HTTP, TLS, SMTP and Redis probes")
+  bannerTimeout = flag.Duration("banner_timeout", 3*time.Second, "timeout of each banner connection")
+  bannerBytes = flag.Int("banner_bytes", 2048, "maximum number of bytes kept per banner")
+  httpProbe = flag.Bool("http_probe", true, "probe open ports for web servers over HTTPS and HTTP and record their responses")
+  httpProbeTimeout = flag.Duration("http_probe_timeout", 10*time.Second, "timeout of each HTTP probe, including redirects")
+  httpProbeBytes = flag.Int64("http_probe_bytes", 1<<20, "maximum number of body bytes read per HTTP probe")
+  favicons = flag.Bool("favicons", true, "fetch and hash the favicons of web servers found by the HTTP probe")
+  faviconDB = flag.String("favicon_db", "favicons.json", "JSON file mapping mmh3 or sha256 favicon hashes to the products serving them")
+  techRules = flag.String("tech_rules", "technologies.json", "Wappalyzer technologies JSON file, or directory of them, used to detect the technologies of web servers, disabled if empty")
+  checkRegistrable = flag.Bool("registrable", true, "check whether the domains CNAME, NS and MX records point into can be registered, using whois")
+  whoisCacheTTL = flag.Duration("whois_cache_ttl", 24*time.Hour, "how long the whois availability of a domain is cached for")
+  cloudRanges = flag.String("cloud_ranges", "", "directory of AWS, GCP and Azure IP range JSON files used to flag possibly released cloud addresses, disabled if empty")
+  cloudTimeout = flag.Duration("cloud_timeout", 3*time.Second, "timeout of each cloud address connection and request")
+  cloudInterval = flag.Duration("cloud_interval", 24*time.Hour, "time to wait between rechecks of stored cloud addresses")
+  screenshotWait = flag.String("screenshot_wait", "load", "when a page is ready to screenshot: load, networkidle, delay or selector")
+  screenshotDelay = flag.Duration("screenshot_delay", 2*time.Second, "time to wait after the load event with the delay strategy")
+  screenshotSelector = flag.String("screenshot_selector", "", "CSS selector to wait for with the selector strategy")
+  screenshotTimeout = flag.Duration("screenshot_timeout", 30*time.Second, "maximum time spent loading and capturing each page")
+  screenshotWidth = flag.Int64("screenshot_width", 1366, "width of the screenshot viewport")
+  screenshotHeight = flag.Int64("screenshot_height", 768, "height of the screenshot viewport")
+  screenshotFullPage = flag.Bool("screenshot_full_page", false, "capture whole pages rather than the viewport")
+  screenshotUserAgent = flag.String("screenshot_user_agent", "", "user agent to screenshot pages with, defaults to Chrome's")
+  screenshotTabs = flag.Int("screenshot_tabs", 4, "number of browser tabs screenshots are taken in concurrently")
+  screenshotQueue = flag.Int("screenshot_queue", 100, "number of pages that can wait for a free tab before new subdomains wait too")
+  screenshotDir = flag.String("screenshot_dir", "screenshots", "directory screenshots are stored in, unless an S3 endpoint is set")
+  screenshotS3Endpoint = flag.String("screenshot_s3_endpoint", "", "URL of an S3-compatible service to store screenshots in e.g. http://localhost:9000 for MinIO")
+  screenshotS3Bucket = flag.String("screenshot_s3_bucket", "bounty-hunter", "bucket to store screenshots in")
+  screenshotS3Region = flag.String("screenshot_s3_region", "us-east-1", "region of the screenshot bucket")
+  screenshotS3KeyEnv = flag.String("screenshot_s3_key_env", "S3_ACCESS_KEY_ID", "name of env variable holding the S3 access key")
+  screenshotS3SecretEnv = flag.String("screenshot_s3_secret_env", "S3_SECRET_ACCESS_KEY", "name of env variable holding the S3 secret key")
+  screenshotChangeThreshold = flag.Int("screenshot_change_threshold", 10, "perceptual hash distance out of 64 from the previous screenshot of a page at which it is reported as changed, never if 0")
+  screenshotClusterDistance = flag.Int("screenshot_cluster_distance", 6, "perceptual hash distance out of 64 within which screenshots of pages with the same title are clustered, no clustering if 0")
+  screenshotClusterInterval = flag.Duration("screenshot_cluster_interval", 10*time.Minute, "how often a single alert is sent for each cluster that gained screenshots")
+  screenshotRetention = flag.Duration("screenshot_retention", 0, "how long screenshots are kept, the latest of each URL is always kept, forever if 0")
+  dnsServer = flag.String("resolver", "", "DNS server to use for lookups e.g. 1.1.1.1:53, defaults to the system resolver")
+  dnsTimeout = flag.Duration("resolver_timeout", 5*time.Second, "timeout of a single DNS lookup")
+  permutations = flag.Bool("permute", false, "resolve permutations of new subdomains to find unlisted siblings")
+  permuteLimit = flag.Int("permute_limit", 500, "maximum number of permutations resolved per domain on each run")
+  permuteWorkers = flag.Int("permute_workers", 20, "number of concurrent permutation lookups")
+  wordlist = flag.String("bruteforce_wordlist", "", "wordlist to brute force subdomains of every stored domain with, disabled if empty")
+  bruteforceWorkers = flag.Int("bruteforce_workers", 20, "number of concurrent brute force lookups")
+  bruteforceRate = flag.Int("bruteforce_rate", 100, "maximum number of brute force lookups per second")
+  axfr = flag.Bool("axfr", true, "attempt zone transfers against the nameservers of new domains")
+  axfrPort = flag.String("axfr_port", "53", "port to attempt zone transfers on")
+  axfrTimeout = flag.Duration("axfr_timeout", 10*time.Second, "timeout of a single zone transfer")
+  bruteforceInterval = flag.Duration("bruteforce_interval", 24*time.Hour, "time to wait between brute force runs")
   manualTargets = []string{"*.sandyou.be", "*.synergiecareers.be", "*.synergieconstruct.be", "*.werkenbijsynergie.be"}
 )
 
@@ -37,6 +122,13 @@ func main() {
   flag.Parse()
   ctx := context.Background()
 
+  if flag.NArg() > 0 {
+    if err := runCommand(ctx, flag.Args()); err != nil {
+      log.Fatal(err)
+    }
+    return
+  }
+
   // Fetch bug bounty targets as regexes.
   // TODO: Fetch every hour.
   regexes, err := fetchBountyTargets()
@@ -53,20 +145,157 @@ func main() {
   if err != nil {
     log.Fatalf("failed to creat slack client: %v", err)
   }
-  subjack, err := takeover.New(db, slack, *fingerprints)
+  scanOpts := &portscan.Options{
+    Scanner: *scanner,
+    Timeout: *scanTimeout,
+    Workers: *scanWorkers,
+    Rate: *scanRate,
+    ServiceDetection: *serviceDetection,
+    CacheTTL: *scanCacheTTL,
+    CDNPolicy: *cdnPolicy,
+    UDP: *udp,
+  }
+  if *cdnPolicy != portscan.CDNScan && *cdnPolicy != portscan.CDNWeb && *cdnPolicy != portscan.CDNSkip {
+    log.Fatalf("unknown cdn policy %q", *cdnPolicy)
+  }
+  if *scanner != portscan.ScannerNmap && *scanner != portscan.ScannerConnect {
+    log.Fatalf("unknown scanner %q", *scanner)
+  }
+  if *scanWorkers <= 0 {
+    log.Fatalf("scan_workers must be positive, got %d", *scanWorkers)
+  }
+  // Lookups are spaced time.Second/rate apart, which is zero above 1e9.
+  if *bruteforceRate < 1 || *bruteforceRate > int(time.Second) {
+    log.Fatalf("bruteforce_rate must be between 1 and 1e9, got %d", *bruteforceRate)
+  }
+  if scanOpts.Profiles, err = portscan.LoadProfiles(*scanProfiles); err != nil {
+    log.Fatalf("failed to load scan profiles: %v", err)
+  }
+  if *scanProfile != "" {
+    if err := scanOpts.Profiles.SetDefault(*scanProfile); err != nil {
+      log.Fatal(err)
+    }
+  }
+  if err := scanOpts.Profiles.CheckScanner(*scanner); err != nil {
+    log.Fatal(err)
+  }
+  if *scanPorts != "" {
+    if scanOpts.Ports, err = portscan.ParsePorts(*scanPorts); err != nil {
+      log.Fatalf("failed to parse ports: %v", err)
+    }
+  }
+  dns := resolver.New(*dnsServer, *dnsTimeout)
+  subjack, err := takeover.New(db, slack, dns, *fingerprints, *takeoverTimeout, *takeoverHTTPS)
   if err != nil {
-    log.Fatalf("failed to create subjack client: %v", err)
+    log.Fatalf("failed to create takeover client: %v", err)
   }
-  nmap := portscan.New(db, slack)
-  chrome := screenshot.New(ctx)
+  go reloadFingerprints(subjack)
+  if *fingerprintsWatch > 0 {
+    go subjack.Watch(ctx, *fingerprintsWatch)
+  }
+  if *udpPorts != "" {
+    if scanOpts.UDPPorts, err = portscan.ParsePorts(*udpPorts); err != nil {
+      log.Fatalf("failed to parse udp ports: %v", err)
+    }
+  }
+  nmap := portscan.New(db, slack, dns, scanOpts)
+  switch *screenshotWait {
+  case screenshot.WaitLoad, screenshot.WaitNetworkIdle, screenshot.WaitDelay:
+  case screenshot.WaitSelector:
+    if *screenshotSelector == "" {
+      log.Fatal("screenshot_selector must be set with the selector wait strategy")
+    }
+  default:
+    log.Fatalf("unknown screenshot wait strategy %q", *screenshotWait)
+  }
+  if *screenshotTabs <= 0 {
+    log.Fatalf("screenshot_tabs must be positive, got %d", *screenshotTabs)
+  }
+  store, err := newScreenshotStore()
+  if err != nil {
+    log.Fatalf("failed to create screenshot store: %v", err)
+  }
+  chrome := screenshot.New(ctx, db, slack, store, &screenshot.Options{
+    Wait: *screenshotWait,
+    Delay: *screenshotDelay,
+    Selector: *screenshotSelector,
+    Timeout: *screenshotTimeout,
+    Width: *screenshotWidth,
+    Height: *screenshotHeight,
+    FullPage: *screenshotFullPage,
+    UserAgent: *screenshotUserAgent,
+    ChangeThreshold: *screenshotChangeThreshold,
+    ClusterDistance: *screenshotClusterDistance,
+  }, *screenshotTabs, *screenshotQueue)
   defer chrome.Close()
+  if *screenshotRetention > 0 {
+    go chrome.Prune(ctx, *screenshotRetention)
+  }
+  if *screenshotClusterDistance > 0 {
+    go chrome.NotifyClusters(ctx, *screenshotClusterInterval)
+  }
+
+  h := &hunter{
+    db: db,
+    slack: slack,
+    subjack: subjack,
+    nmap: nmap,
+    chrome: chrome,
+    resolver: dns,
+    regexes: regexes,
+  }
+  if *banners {
+    h.banners = banner.New(db, *bannerTimeout, *bannerBytes)
+  }
+  if *httpProbe {
+    var knownFavicons httpprobe.FaviconDB
+    if *favicons {
+      knownFavicons = httpprobe.FaviconDB{}
+      if *faviconDB != "" {
+        if knownFavicons, err = httpprobe.LoadFaviconDB(*faviconDB); err != nil {
+          log.Fatalf("failed to load favicon db: %v", err)
+        }
+      }
+    }
+    h.httpProbe = httpprobe.New(db, *httpProbeTimeout, *httpProbeBytes, knownFavicons)
+  }
+  if *httpProbe && *techRules != "" {
+    rules, err := tech.LoadRules(*techRules)
+    if err != nil {
+      log.Fatalf("failed to load tech rules: %v", err)
+    }
+    h.tech = tech.New(db, rules)
+  }
+  if *checkRegistrable {
+    h.registrable = registrable.New(db, slack, dns, registrable.Whois{}, *whoisCacheTTL)
+  }
+  if *cloudRanges != "" {
+    ranges, err := cloudip.LoadRanges(*cloudRanges)
+    if err != nil {
+      log.Fatalf("failed to load cloud ranges: %v", err)
+    }
+    h.cloudIPs = cloudip.New(db, slack, dns, ranges, *cloudTimeout)
+    go h.cloudIPs.Recheck(ctx, *cloudInterval)
+  }
+  if *permutations {
+    h.permuter = permute.New(db, dns, *permuteLimit, *permuteWorkers)
+  }
+  if *axfr {
+    h.zoneTransfer = zonetransfer.New(db, slack, dns, *axfrPort, *axfrTimeout)
+  }
+  if *wordlist != "" {
+    h.bruteforcer, err = bruteforce.New(dns, *wordlist, *bruteforceWorkers, *bruteforceRate)
+    if err != nil {
+      log.Fatalf("failed to create brute force client: %v", err)
+    }
+    go h.bruteforce(ctx, *bruteforceInterval)
+  }
 
   // Kick off certstream.
   stream, errStream := certstream.CertStreamEventStream(false)
   for {
     select {
     case jq := <-stream:
-      // TODO: refactor
       go func() {
         // Skip heartbeat messages.
         messageType, err := jq.String("message_type")
@@ -87,75 +316,10 @@ func main() {
         for _, sub := range subdomains {
           for _, regex := range regexes {
             if regex.MatchString(sub) {
-              if !resolves(sub) {
-                continue
-              }
-              // Parse tld+1 for base domain.
-              domainName, err := publicsuffix.EffectiveTLDPlusOne(sub)
-              if err != nil {
-                log.Fatalf("failed to parse domain name: %v", err)
-              }
-              domain := &storage.Domain{
-                Name: domainName,
-              }
-              // Insert domain into db for tracking.
-              if err := db.InsertDomain(domain); err != nil {
-                log.Fatalf("failed to insert domain %v into db: %v", domain, err)
-              }
-              subdomain := &storage.Subdomain{
-                Name: sub,
-                Domain: domain.Name,
-              }
-              // Check for existence of found subdomain.
-              exists, err := db.SubdomainExists(subdomain)
-              if err != nil {
-                log.Fatalf("failed to check for existence of subdomain %v: %v", subdomain, err)
-              }
-              // If it doesn't exist, insert but wait to notify until scans are
-              // done.
-              if !exists {
-                log.Printf("Found new subdomain: %q", subdomain.Name)
-                // Insert subdomain into db.
-                if err := db.InsertSubdomain(subdomain); err != nil {
-                  log.Fatalf("failed to insert subdomain into db: %v", err)
-                }
-                // Run scanners.
-                portsc := make(chan []*storage.Port, 1)
-                takeoverc := make(chan string, 1)
-                go nmap.Scan(ctx, subdomain, exists, portsc)
-                go subjack.Identify(subdomain, exists, takeoverc)
-                subdomain.Ports = <-portsc
-                subdomain.Takeover = <-takeoverc
-
-                // Run analysis e.g. screenshots on web servers.
-                done := make(chan bool, 1)
-                go chrome.Screenshot(subdomain, done)
-                <-done
-                if err := slack.NotifySubdomain(subdomain); err != nil {
-                  log.Fatalf("failed to notify new subdomain %v: %v", subdomain, err)
-                }
-              } else {
-                log.Printf("Found existing subdomain: %q", subdomain.Name)
+              subdomain := h.process(ctx, sub, storage.SourceCertstream)
+              if subdomain != nil && h.permuter != nil {
+                h.permute(ctx, subdomain)
               }
-              // TODO: fix redundant alerts. For now, only scan new domains.
-              /*
-              // Run scanners regardless of whether subdomain is new.
-              portsc := make(chan []*storage.Port, 1)
-              takeoverc := make(chan string, 1)
-              go nmap.Scan(ctx, subdomain, exists, portsc)
-              go subjack.Identify(subdomain, exists, takeoverc)
-              subdomain.Ports = <-portsc
-              subdomain.Takeover = <-takeoverc
-              // If the subdomain is new, now we notify with full scan results.
-              if !exists {
-                // Run analysis e.g. screenshots on web servers.
-                done := make(chan bool, 1)
-                go chrome.Screenshot(subdomain, done)
-                <-done
-                if err := slack.NotifySubdomain(subdomain); err != nil {
-                  log.Fatalf("failed to notify new subdomain %v: %v", subdomain, err)
-                }
-              }*/
             }
           }
         }
@@ -167,6 +331,221 @@ func main() {
   }
 }
 
+// reloadFingerprints reloads the takeover fingerprints on every SIGHUP.
+func reloadFingerprints(subjack *takeover.Client) {
+  hup := make(chan os.Signal, 1)
+  signal.Notify(hup, syscall.SIGHUP)
+  for range hup {
+    if err := subjack.Reload(); err != nil {
+      log.Printf("failed to reload fingerprints, keeping the previous ones: %v", err)
+    }
+  }
+}
+
+// hunter holds the dependencies of the subdomain pipeline.
+type hunter struct {
+  db *storage.Client
+  slack *notify.Client
+  subjack *takeover.Client
+  nmap *portscan.Client
+  chrome *screenshot.Client
+  resolver *resolver.Client
+  permuter *permute.Client
+  banners *banner.Client
+  httpProbe *httpprobe.Client
+  tech *tech.Client
+  bruteforcer *bruteforce.Client
+  zoneTransfer *zonetransfer.Client
+  registrable *registrable.Client
+  cloudIPs *cloudip.Client
+  regexes []*regexp.Regexp
+}
+
+// process inserts a resolving subdomain into the db and, if it hasn't been seen
+// before, runs the scanners and notifies. It returns the subdomain if it was
+// new, otherwise nil.
+func (h *hunter) process(ctx context.Context, sub, source string) *storage.Subdomain {
+  if !h.resolver.Resolves(ctx, sub) {
+    // Names delegated to nameservers that don't serve them never resolve, so
+    // keep them if the delegation is dangling.
+    if t, err := h.subjack.CheckNS(ctx, sub); err != nil || t == nil {
+      return nil
+    }
+  }
+  // Parse tld+1 for base domain.
+  domainName, err := publicsuffix.EffectiveTLDPlusOne(sub)
+  if err != nil {
+    log.Fatalf("failed to parse domain name: %v", err)
+  }
+  domain := &storage.Domain{
+    Name: domainName,
+  }
+  // Insert domain into db for tracking.
+  newDomain, err := h.db.InsertDomain(domain)
+  if err != nil {
+    log.Fatalf("failed to insert domain %v into db: %v", domain, err)
+  }
+  if newDomain && h.zoneTransfer != nil {
+    go h.transfer(ctx, domain)
+  }
+  if newDomain {
+    apex := &storage.Subdomain{Name: domain.Name, Domain: domain.Name}
+    go h.subjack.IdentifyDelegation(ctx, apex, true)
+    if h.registrable != nil {
+      go h.registrable.Identify(ctx, apex)
+    }
+  }
+  subdomain := &storage.Subdomain{
+    Name: sub,
+    Domain: domain.Name,
+    Source: source,
+  }
+  // Check for existence of found subdomain.
+  exists, err := h.db.SubdomainExists(subdomain)
+  if err != nil {
+    log.Fatalf("failed to check for existence of subdomain %v: %v", subdomain, err)
+  }
+  if exists {
+    log.Printf("Found existing subdomain: %q", subdomain.Name)
+    if err := h.db.RecordSource(subdomain, source); err != nil {
+      log.Fatalf("failed to record source of subdomain %v: %v", subdomain, err)
+    }
+    return nil
+  }
+  // If it doesn't exist, insert but wait to notify until scans are done.
+  log.Printf("Found new subdomain: %q", subdomain.Name)
+  // Insert subdomain into db.
+  if err := h.db.InsertSubdomain(subdomain); err != nil {
+    log.Fatalf("failed to insert subdomain into db: %v", err)
+  }
+  if err := h.db.RecordSource(subdomain, source); err != nil {
+    log.Fatalf("failed to record source of subdomain %v: %v", subdomain, err)
+  }
+  if subdomain.Sources, err = h.db.Sources(subdomain); err != nil {
+    log.Fatalf("failed to fetch sources of subdomain %v: %v", subdomain, err)
+  }
+  // Run scanners.
+  // TODO: fix redundant alerts. For now, only scan new domains.
+  portsc := make(chan []*storage.Port, 1)
+  takeoverc := make(chan string, 1)
+  go h.nmap.Scan(ctx, subdomain, exists, portsc)
+  go h.subjack.Identify(ctx, subdomain, exists, takeoverc)
+  if h.registrable != nil {
+    go h.registrable.Identify(ctx, &storage.Subdomain{Name: subdomain.Name, Domain: subdomain.Domain})
+  }
+  if h.cloudIPs != nil {
+    go func(subdomain *storage.Subdomain) {
+      if _, err := h.cloudIPs.Check(ctx, subdomain); err != nil {
+        log.Printf("failed to check cloud addresses of %q: %v", subdomain.Name, err)
+      }
+    }(&storage.Subdomain{Name: subdomain.Name, Domain: subdomain.Domain})
+  }
+  subdomain.Ports = <-portsc
+  subdomain.Takeover = <-takeoverc
+
+  // Run analysis e.g. banners and screenshots on web servers.
+  if h.banners != nil {
+    if err := h.banners.Grab(ctx, subdomain); err != nil {
+      log.Fatalf("failed to grab banners of subdomain %v: %v", subdomain, err)
+    }
+  }
+  if h.httpProbe != nil {
+    if err := h.httpProbe.Probe(ctx, subdomain); err != nil {
+      log.Fatalf("failed to probe web servers of subdomain %v: %v", subdomain, err)
+    }
+  }
+  if h.tech != nil {
+    if err := h.tech.Detect(subdomain); err != nil {
+      log.Fatalf("failed to detect technologies of subdomain %v: %v", subdomain, err)
+    }
+  }
+  done := make(chan bool, 1)
+  go h.chrome.Screenshot(subdomain, done)
+  <-done
+  if err := h.slack.NotifySubdomain(subdomain); err != nil {
+    log.Fatalf("failed to notify new subdomain %v: %v", subdomain, err)
+  }
+  return subdomain
+}
+
+// permute feeds in scope, resolving permutations of a new subdomain back into
+// the pipeline. Permutations aren't permuted in turn, so runs can't cascade.
+func (h *hunter) permute(ctx context.Context, subdomain *storage.Subdomain) {
+  if subdomain.Source == storage.SourcePermutation {
+    return
+  }
+  hits, err := h.permuter.Permute(ctx, subdomain)
+  if err != nil {
+    log.Printf("failed to permute subdomain %q: %v", subdomain.Name, err)
+    return
+  }
+  for _, hit := range hits {
+    if !h.inScope(hit) {
+      continue
+    }
+    log.Printf("Permutation of %q resolves: %q", subdomain.Name, hit)
+    h.process(ctx, hit, storage.SourcePermutation)
+  }
+}
+
+// transfer attempts a zone transfer of a new domain and feeds in scope names
+// from the zone into the pipeline.
+func (h *hunter) transfer(ctx context.Context, domain *storage.Domain) {
+  names, err := h.zoneTransfer.Attempt(ctx, domain)
+  if err != nil {
+    log.Printf("failed to attempt zone transfer of %q: %v", domain.Name, err)
+    return
+  }
+  for _, name := range names {
+    if h.inScope(name) {
+      h.process(ctx, name, storage.SourceAXFR)
+    }
+  }
+}
+
+// inScope returns whether the name matches any of the target regexes.
+func (h *hunter) inScope(name string) bool {
+  for _, regex := range h.regexes {
+    if regex.MatchString(name) {
+      return true
+    }
+  }
+  return false
+}
+
+// bruteforce enumerates every stored domain with the wordlist once per
+// interval and feeds in scope hits into the pipeline.
+func (h *hunter) bruteforce(ctx context.Context, interval time.Duration) {
+  for {
+    domains, err := h.db.Domains()
+    if err != nil {
+      log.Fatalf("failed to fetch domains: %v", err)
+    }
+    for _, domain := range domains {
+      hits, err := h.bruteforcer.Enumerate(ctx, domain.Name)
+      if err != nil {
+        log.Printf("failed to brute force domain %q: %v", domain.Name, err)
+        continue
+      }
+      for _, hit := range hits {
+        if h.inScope(hit) {
+          h.process(ctx, hit, storage.SourceBruteforce)
+        }
+      }
+    }
+    time.Sleep(interval)
+  }
+}
+
+// newScreenshotStore returns the S3 store if an endpoint is set, or else the
+// local one.
+func newScreenshotStore() (screenshot.Store, error) {
+  if *screenshotS3Endpoint != "" {
+    return screenshot.NewS3Store(*screenshotS3Endpoint, *screenshotS3Bucket, *screenshotS3Region, os.Getenv(*screenshotS3KeyEnv), os.Getenv(*screenshotS3SecretEnv))
+  }
+  return screenshot.NewLocalStore(*screenshotDir)
+}
+
 // fetchBountyTargets fetches wildcard domains of bug bounty targets from
 // https://github.com/arkadiyt/bounty-targets-data and return a list of compiled
 // regexes.
@@ -192,13 +571,71 @@ func fetchBountyTargets() ([]*regexp.Regexp, error) {
   return regexes, nil
 }
 
-// resolves performs a DNS A lookup on the domain.
-func resolves(domain string) bool {
-  _, err := net.ResolveIPAddr("ip4", domain)
+// fetchPrograms fetches the programs of every platform from
+// https://github.com/arkadiyt/bounty-targets-data and returns the name of the
+// program each in scope domain belongs to, keyed by domain. Domains in several
+// programs keep the first platform's.
+func fetchPrograms() (map[string]string, error) {
+  programs := map[string]string{}
+  for _, platform := range programPlatforms {
+    res, err := http.Get(fmt.Sprintf(programsURL, platform))
+    if err != nil {
+      return nil, fmt.Errorf("failed to fetch %s programs: %v", platform, err)
+    }
+    // Platforms name the in scope asset differently.
+    var data []struct {
+      Name string `json:"name"`
+      Targets struct {
+        InScope []struct {
+          AssetIdentifier string `json:"asset_identifier"`
+          Target string `json:"target"`
+          Endpoint string `json:"endpoint"`
+          AssetType string `json:"asset_type"`
+          Type string `json:"type"`
+        } `json:"in_scope"`
+      } `json:"targets"`
+    }
+    err = json.NewDecoder(res.Body).Decode(&data)
+    res.Body.Close()
+    if err != nil {
+      return nil, fmt.Errorf("failed to parse %s programs: %v", platform, err)
+    }
+    for _, program := range data {
+      for _, target := range program.Targets.InScope {
+        if mobileTarget.MatchString(target.AssetType + target.Type) {
+          continue
+        }
+        domain := targetDomain(target.AssetIdentifier + target.Target + target.Endpoint)
+        if _, ok := programs[domain]; domain == "" || ok {
+          continue
+        }
+        programs[domain] = fmt.Sprintf("%s (%s)", program.Name, platform)
+      }
+    }
+  }
+  return programs, nil
+}
+
+// targetDomain returns the registered domain of a program's target, such as
+// "*.example.com" or "https://app.example.com/login", or "" if the target
+// isn't a host e.g. an IP range or mobile app.
+func targetDomain(target string) string {
+  host := strings.ToLower(strings.TrimSpace(target))
+  if i := strings.Index(host, "://"); i >= 0 {
+    host = host[i+3:]
+  }
+  if i := strings.IndexAny(host, "/:?# "); i >= 0 {
+    host = host[:i]
+  }
+  host = strings.TrimPrefix(host, "*.")
+  if !strings.Contains(host, ".") || strings.ContainsAny(host, "*()") || net.ParseIP(host) != nil {
+    return ""
+  }
+  domain, err := publicsuffix.EffectiveTLDPlusOne(host)
   if err != nil {
-    return false
+    return ""
   }
-  return true
+  return domain
 }
 
 // dedupe removes duplicate strings from a string array.
@@ -0,0 +1,115 @@
+// Package bruteforce enumerates subdomains of stored domains from a wordlist.
+package bruteforce
+
+import (
+  "bufio"
+  "context"
+  "fmt"
+  "os"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/dlegs/bounty-hunter/resolver"
+)
+
+// Client holds the resolver dependency and wordlist.
+type Client struct {
+  resolver *resolver.Client
+  words []string
+  // Number of concurrent lookups.
+  workers int
+  // Maximum number of lookups per second across all workers.
+  rate int
+}
+
+// New returns a new brute force client reading words from wordlistFile.
+func New(resolver *resolver.Client, wordlistFile string, workers, rate int) (*Client, error) {
+  if workers <= 0 || rate <= 0 {
+    return nil, fmt.Errorf("workers and rate must be positive, got %d and %d", workers, rate)
+  }
+  if rate > int(time.Second) {
+    return nil, fmt.Errorf("rate must be at most 1e9 lookups per second, got %d", rate)
+  }
+  f, err := os.Open(wordlistFile)
+  if err != nil {
+    return nil, fmt.Errorf("failed to open wordlist: %v", err)
+  }
+  defer f.Close()
+
+  seen := map[string]bool{}
+  words := []string{}
+  scanner := bufio.NewScanner(f)
+  for scanner.Scan() {
+    word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+    if word == "" || strings.HasPrefix(word, "#") || seen[word] {
+      continue
+    }
+    seen[word] = true
+    words = append(words, word)
+  }
+  if err := scanner.Err(); err != nil {
+    return nil, fmt.Errorf("failed to read wordlist: %v", err)
+  }
+  if len(words) == 0 {
+    return nil, fmt.Errorf("wordlist %q is empty", wordlistFile)
+  }
+  return &Client{
+    resolver: resolver,
+    words: words,
+    workers: workers,
+    rate: rate,
+  }, nil
+}
+
+// Enumerate resolves <word>.<domain> for every word and returns the names that
+// resolve. If the domain has a wildcard record, names resolving only to the
+// wildcard's addresses are dropped.
+func (c *Client) Enumerate(ctx context.Context, domain string) ([]string, error) {
+  wildcard, err := c.resolver.WildcardIPs(ctx, domain)
+  if err != nil {
+    return nil, err
+  }
+
+  // Spread lookups evenly so the rate limit holds across all workers.
+  ticker := time.NewTicker(time.Second / time.Duration(c.rate))
+  defer ticker.Stop()
+
+  namec := make(chan string)
+  hitc := make(chan string)
+  var wg sync.WaitGroup
+  for i := 0; i < c.workers; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for name := range namec {
+        ips, err := c.resolver.LookupIP(ctx, name)
+        if err != nil || len(ips) == 0 || resolver.OnlyWildcard(ips, wildcard) {
+          continue
+        }
+        hitc <- name
+      }
+    }()
+  }
+  go func() {
+    defer func() {
+      close(namec)
+      wg.Wait()
+      close(hitc)
+    }()
+    for _, word := range c.words {
+      select {
+      case <-ctx.Done():
+        return
+      case <-ticker.C:
+      }
+      namec <- fmt.Sprintf("%s.%s", word, domain)
+    }
+  }()
+
+  hits := []string{}
+  for hit := range hitc {
+    hits = append(hits, hit)
+  }
+  return hits, ctx.Err()
+}
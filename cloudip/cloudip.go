@@ -0,0 +1,235 @@
+// Package cloudip classifies the addresses subdomains resolve to by cloud
+// provider and flags addresses that may have been released back to the
+// provider's pool, where anyone can allocate them.
+package cloudip
+
+import (
+  "bytes"
+  "context"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "log"
+  "net"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/dlegs/bounty-hunter/notify"
+  "github.com/dlegs/bounty-hunter/resolver"
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// Maximum number of body bytes read from each default page check.
+const maxBodyBytes = 64 * 1024
+
+// probePorts are tried to tell whether an address still responds.
+var probePorts = []int{80, 443, 22}
+
+// defaultPages are served by freshly allocated instances and services of each
+// provider, before anything has been deployed on them.
+var defaultPages = map[string][]string{
+  ProviderAWS: {
+    "Congratulations! Your Docker Container is now running in Elastic Beanstalk",
+    "Your first AWS Elastic Beanstalk",
+    "Test Page for the Apache HTTP Server on Amazon Linux",
+    "Welcome to nginx on Amazon Linux",
+  },
+  ProviderGCP: {
+    "Congratulations! You have successfully deployed your App Engine",
+    "Welcome to Google Cloud Platform",
+  },
+  ProviderAzure: {
+    "Your web app is running and waiting for your content",
+    "Microsoft Azure App Service - Welcome",
+    "Your Azure Function App is up and running",
+  },
+}
+
+// Client holds db, slack and resolver dependencies and the provider ranges.
+type Client struct {
+  db *storage.Client
+  slack *notify.Client
+  resolver *resolver.Client
+  ranges Ranges
+  timeout time.Duration
+  http *http.Client
+}
+
+// New returns a new cloud IP client.
+func New(db *storage.Client, slack *notify.Client, resolver *resolver.Client, ranges Ranges, timeout time.Duration) *Client {
+  return &Client{
+    db: db,
+    slack: slack,
+    resolver: resolver,
+    ranges: ranges,
+    timeout: timeout,
+    http: &http.Client{
+      Timeout: timeout,
+      CheckRedirect: func(req *http.Request, via []*http.Request) error {
+        return http.ErrUseLastResponse
+      },
+    },
+  }
+}
+
+// Check resolves the subdomain and records each cloud address it points at.
+// Addresses that stopped responding or serve a default provider page are
+// stored as takeovers and notified the first time they're found. Takeovers of
+// subdomains that look healthy again are marked fixed.
+func (c *Client) Check(ctx context.Context, subdomain *storage.Subdomain) ([]*storage.Takeover, error) {
+  ips, err := c.resolver.LookupIP(ctx, subdomain.Name)
+  if err != nil {
+    ips = nil
+  }
+  takeovers := []*storage.Takeover{}
+  for _, ip := range ips {
+    rng := c.ranges.Classify(ip)
+    if rng == nil {
+      continue
+    }
+    t, err := c.checkIP(ctx, subdomain, ip, rng)
+    if err != nil {
+      return nil, err
+    }
+    if t != nil {
+      takeovers = append(takeovers, t)
+    }
+  }
+  if len(takeovers) == 0 {
+    return nil, c.db.FixTakeovers(subdomain, storage.TakeoverIP)
+  }
+  for _, t := range takeovers {
+    log.Printf("Possible IP takeover of %q (%s): %s", subdomain.Name, t.Confidence, strings.Join(t.Records, ", "))
+    if err := c.db.UpsertTakeover(t); err != nil {
+      return nil, err
+    }
+    // Rechecks find the same takeovers again, so only notify new ones.
+    if !t.FirstSeen.Equal(t.LastSeen) {
+      continue
+    }
+    if err := c.slack.NotifyTakeover(&storage.Subdomain{Name: subdomain.Name, Takeover: t.Service, TakeoverEvidence: t}); err != nil {
+      log.Printf("failed to notify takeover of %q: %v", subdomain.Name, err)
+    }
+  }
+  return takeovers, nil
+}
+
+// checkIP records a check of a single cloud address and returns a takeover if
+// it looks released.
+func (c *Client) checkIP(ctx context.Context, subdomain *storage.Subdomain, ip net.IP, rng *Range) (*storage.Takeover, error) {
+  responding := c.responds(ctx, ip)
+  cloudIP := &storage.CloudIP{
+    Subdomain: subdomain.Name,
+    IP: ip.String(),
+    Provider: rng.Provider,
+    Region: rng.Region,
+    Service: rng.Service,
+    Responding: responding,
+  }
+  lastResponding, err := c.db.UpsertCloudIP(cloudIP)
+  if err != nil {
+    return nil, err
+  }
+  takeover := &storage.Takeover{
+    Subdomain: subdomain.Name,
+    Kind: storage.TakeoverIP,
+    Service: strings.Join(nonEmpty(rng.Provider, rng.Service), "-"),
+  }
+  record := fmt.Sprintf("A %s: %s", ip, strings.Join(nonEmpty(rng.Provider, rng.Service, rng.Region), " "))
+  if !responding {
+    // Hosts that never responded are most likely firewalled.
+    if lastResponding.IsZero() {
+      return nil, nil
+    }
+    takeover.Confidence = storage.ConfidenceIPUnresponsive
+    takeover.Records = []string{fmt.Sprintf("%s, not responding since %s", record, lastResponding.Format(time.RFC3339))}
+    return takeover, nil
+  }
+  url, status, excerpt := c.defaultPage(ctx, subdomain.Name, ip, rng.Provider)
+  if url == "" {
+    return nil, nil
+  }
+  takeover.Confidence = storage.ConfidenceIPDefaultPage
+  takeover.Records = []string{record + ", serving a default page"}
+  takeover.URL = url
+  takeover.StatusCode = status
+  takeover.Excerpt = excerpt
+  return takeover, nil
+}
+
+// responds returns whether any probe port of the address accepts connections.
+func (c *Client) responds(ctx context.Context, ip net.IP) bool {
+  dialer := net.Dialer{Timeout: c.timeout}
+  for _, port := range probePorts {
+    conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+    if err == nil {
+      conn.Close()
+      return true
+    }
+  }
+  return false
+}
+
+// defaultPage requests the subdomain from the address over HTTP and returns
+// the url, status and matching default page string if the response is one of
+// the provider's default pages.
+func (c *Client) defaultPage(ctx context.Context, host string, ip net.IP, provider string) (string, int, string) {
+  url := fmt.Sprintf("http://%s/", net.JoinHostPort(ip.String(), "80"))
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+  if err != nil {
+    return "", 0, ""
+  }
+  req.Host = host
+  res, err := c.http.Do(req)
+  if err != nil {
+    return "", 0, ""
+  }
+  defer res.Body.Close()
+  body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxBodyBytes))
+  if err != nil {
+    return "", 0, ""
+  }
+  for _, page := range defaultPages[provider] {
+    if bytes.Contains(body, []byte(page)) {
+      return url, res.StatusCode, page
+    }
+  }
+  return "", 0, ""
+}
+
+// Recheck checks every subdomain that has resolved to a cloud address every
+// interval until ctx is done, so addresses that stop responding are noticed.
+func (c *Client) Recheck(ctx context.Context, interval time.Duration) {
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+    }
+    subdomains, err := c.db.CloudIPSubdomains()
+    if err != nil {
+      log.Printf("failed to list cloud ip subdomains: %v", err)
+      continue
+    }
+    log.Printf("Rechecking cloud addresses of %d subdomains", len(subdomains))
+    for _, subdomain := range subdomains {
+      if _, err := c.Check(ctx, subdomain); err != nil {
+        log.Printf("failed to check cloud addresses of %q: %v", subdomain.Name, err)
+      }
+    }
+  }
+}
+
+func nonEmpty(values ...string) []string {
+  out := []string{}
+  for _, v := range values {
+    if v != "" {
+      out = append(out, v)
+    }
+  }
+  return out
+}
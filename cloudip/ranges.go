@@ -0,0 +1,166 @@
+package cloudip
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+// Cloud providers.
+const (
+  ProviderAWS = "aws"
+  ProviderGCP = "gcp"
+  ProviderAzure = "azure"
+)
+
+// Range is a published address range of a cloud provider.
+type Range struct {
+  Net *net.IPNet
+  Provider string
+  Region string
+  Service string
+}
+
+// Ranges holds the address ranges of every loaded provider.
+type Ranges []*Range
+
+// rangesFile holds the fields of the AWS ip-ranges.json, GCP cloud.json and
+// Azure ServiceTags_Public.json formats, which don't overlap.
+type rangesFile struct {
+  // AWS and GCP.
+  Prefixes []struct {
+    IPPrefix string `json:"ip_prefix"`
+    Region string `json:"region"`
+    Service string `json:"service"`
+    IPv4Prefix string `json:"ipv4Prefix"`
+    IPv6Prefix string `json:"ipv6Prefix"`
+    Scope string `json:"scope"`
+  } `json:"prefixes"`
+  IPv6Prefixes []struct {
+    IPv6Prefix string `json:"ipv6_prefix"`
+    Region string `json:"region"`
+    Service string `json:"service"`
+  } `json:"ipv6_prefixes"`
+  // Azure.
+  Values []struct {
+    Name string `json:"name"`
+    Properties struct {
+      Region string `json:"region"`
+      SystemService string `json:"systemService"`
+      AddressPrefixes []string `json:"addressPrefixes"`
+    } `json:"properties"`
+  } `json:"values"`
+}
+
+// LoadRanges reads the AWS, GCP and Azure range files in dir, telling the
+// format of each file from its contents.
+func LoadRanges(dir string) (Ranges, error) {
+  files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+  if err != nil {
+    return nil, fmt.Errorf("failed to list cloud range files: %v", err)
+  }
+  if len(files) == 0 {
+    return nil, fmt.Errorf("no cloud range files found in %s", dir)
+  }
+  ranges := Ranges{}
+  for _, file := range files {
+    data, err := ioutil.ReadFile(file)
+    if err != nil {
+      return nil, fmt.Errorf("failed to read cloud range file: %v", err)
+    }
+    parsed, err := ParseRanges(data)
+    if err != nil {
+      return nil, fmt.Errorf("%s: %v", file, err)
+    }
+    ranges = append(ranges, parsed...)
+  }
+  // Most specific ranges first, so a lookup finds the service before the
+  // provider wide range containing it. AWS lists some ranges under both a
+  // service and the catch-all AMAZON, so prefer the service on ties.
+  sort.SliceStable(ranges, func(i, j int) bool {
+    a, _ := ranges[i].Net.Mask.Size()
+    b, _ := ranges[j].Net.Mask.Size()
+    if a != b {
+      return a > b
+    }
+    return !generic(ranges[i]) && generic(ranges[j])
+  })
+  return ranges, nil
+}
+
+// ParseRanges parses a single AWS, GCP or Azure range file.
+func ParseRanges(data []byte) (Ranges, error) {
+  f := &rangesFile{}
+  if err := json.Unmarshal(data, f); err != nil {
+    return nil, fmt.Errorf("failed to parse cloud ranges json: %v", err)
+  }
+  ranges := Ranges{}
+  add := func(cidr, provider, region, service string) error {
+    _, n, err := net.ParseCIDR(cidr)
+    if err != nil {
+      return fmt.Errorf("failed to parse %s range %q: %v", provider, cidr, err)
+    }
+    ranges = append(ranges, &Range{
+      Net: n,
+      Provider: provider,
+      Region: strings.ToLower(region),
+      Service: strings.ToLower(service),
+    })
+    return nil
+  }
+  for _, p := range f.Prefixes {
+    var err error
+    switch {
+    case p.IPPrefix != "":
+      err = add(p.IPPrefix, ProviderAWS, p.Region, p.Service)
+    case p.IPv4Prefix != "":
+      err = add(p.IPv4Prefix, ProviderGCP, p.Scope, p.Service)
+    case p.IPv6Prefix != "":
+      err = add(p.IPv6Prefix, ProviderGCP, p.Scope, p.Service)
+    }
+    if err != nil {
+      return nil, err
+    }
+  }
+  for _, p := range f.IPv6Prefixes {
+    if err := add(p.IPv6Prefix, ProviderAWS, p.Region, p.Service); err != nil {
+      return nil, err
+    }
+  }
+  for _, v := range f.Values {
+    service := v.Properties.SystemService
+    if service == "" {
+      service = v.Name
+    }
+    for _, prefix := range v.Properties.AddressPrefixes {
+      if err := add(prefix, ProviderAzure, v.Properties.Region, service); err != nil {
+        return nil, err
+      }
+    }
+  }
+  if len(ranges) == 0 {
+    return nil, fmt.Errorf("no AWS, GCP or Azure ranges found")
+  }
+  return ranges, nil
+}
+
+// generic reports whether the range covers a whole provider or region rather
+// than a service.
+func generic(r *Range) bool {
+  return r.Service == "amazon" || strings.HasPrefix(r.Service, "azurecloud")
+}
+
+// Classify returns the most specific range containing the ip, or nil if it
+// isn't a cloud address.
+func (r Ranges) Classify(ip net.IP) *Range {
+  for _, rng := range r {
+    if rng.Net.Contains(ip) {
+      return rng
+    }
+  }
+  return nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "os"
+  "regexp"
+  "strings"
+  "time"
+
+  "github.com/dlegs/bounty-hunter/portscan"
+  "github.com/dlegs/bounty-hunter/report"
+  "github.com/dlegs/bounty-hunter/resolver"
+  "github.com/dlegs/bounty-hunter/storage"
+  "github.com/dlegs/bounty-hunter/takeover"
+)
+
+// runCommand runs the subcommand named by the first argument.
+func runCommand(ctx context.Context, args []string) error {
+  switch args[0] {
+  case "export":
+    return exportCommand(args[1:])
+  case "banners":
+    return bannersCommand(args[1:])
+  case "takeovers":
+    return takeoversCommand(args[1:])
+  case "import":
+    return importCommand(ctx, args[1:])
+  case "fingerprints":
+    return fingerprintsCommand(args[1:])
+  case "report":
+    return reportCommand(ctx, args[1:])
+  case "favicons":
+    return faviconsCommand(args[1:])
+  default:
+    return fmt.Errorf("unknown command %q", args[0])
+  }
+}
+
+// exportCommand writes stored subdomains and their sources as JSON lines to
+// stdout.
+func exportCommand(args []string) error {
+  fs := flag.NewFlagSet("export", flag.ExitOnError)
+  domain := fs.String("domain", "", "only export subdomains of this domain")
+  source := fs.String("source", "", "only export subdomains observed from this source e.g. certstream, bruteforce")
+  since := fs.Duration("since", 0, "only export subdomains observed within this duration")
+  fs.Parse(args)
+
+  db, err := storage.New(*dbName)
+  if err != nil {
+    return fmt.Errorf("failed to create sqlite client: %v", err)
+  }
+  filter := &storage.SubdomainFilter{
+    Domain: *domain,
+    Source: *source,
+  }
+  if *since > 0 {
+    filter.Since = time.Now().Add(-*since)
+  }
+  subdomains, err := db.FindSubdomains(filter)
+  if err != nil {
+    return fmt.Errorf("failed to find subdomains: %v", err)
+  }
+  enc := json.NewEncoder(os.Stdout)
+  for _, subdomain := range subdomains {
+    if err := enc.Encode(subdomain); err != nil {
+      return fmt.Errorf("failed to encode subdomain %q: %v", subdomain.Name, err)
+    }
+  }
+  return nil
+}
+
+// bannersCommand prints every line of a stored banner matching a regex, like
+// grep across all hosts.
+func bannersCommand(args []string) error {
+  fs := flag.NewFlagSet("banners", flag.ExitOnError)
+  pattern := fs.String("pattern", "", "regex to search banners for e.g. 'Server: nginx/1\\.1'")
+  fs.Parse(args)
+  if *pattern == "" {
+    return fmt.Errorf("-pattern is required")
+  }
+  re, err := regexp.Compile(*pattern)
+  if err != nil {
+    return fmt.Errorf("failed to compile pattern: %v", err)
+  }
+
+  db, err := storage.New(*dbName)
+  if err != nil {
+    return fmt.Errorf("failed to create sqlite client: %v", err)
+  }
+  banners, err := db.SearchBanners(re)
+  if err != nil {
+    return fmt.Errorf("failed to search banners: %v", err)
+  }
+  for _, banner := range banners {
+    for _, line := range strings.Split(banner.Response, "\n") {
+      if re.MatchString(line) {
+        fmt.Printf("%s:%d/%s [%s] %s\n", banner.Subdomain, banner.Port, banner.Protocol, banner.Probe, strings.TrimSpace(line))
+      }
+    }
+  }
+  return nil
+}
+
+// importCommand seeds the db with ports from historical nmap or masscan scans.
+func importCommand(ctx context.Context, args []string) error {
+  fs := flag.NewFlagSet("import", flag.ExitOnError)
+  format := fs.String("format", "nmap", "format of the files, either nmap (-oX) or masscan (-oJ or -oL)")
+  fs.Parse(args)
+  if fs.NArg() == 0 {
+    return fmt.Errorf("usage: import [-format nmap|masscan] <file>...")
+  }
+  var parse func([]byte) ([]*portscan.Host, error)
+  switch *format {
+  case "nmap":
+    parse = portscan.ParseNmapXML
+  case "masscan":
+    parse = portscan.ParseMasscan
+  default:
+    return fmt.Errorf("unknown format %q", *format)
+  }
+
+  db, err := storage.New(*dbName)
+  if err != nil {
+    return fmt.Errorf("failed to create sqlite client: %v", err)
+  }
+  regexes, err := fetchBountyTargets()
+  if err != nil {
+    return fmt.Errorf("failed to fetch bounty targets: %v", err)
+  }
+  inScope := func(name string) bool {
+    for _, regex := range regexes {
+      if regex.MatchString(name) {
+        return true
+      }
+    }
+    return false
+  }
+  importer := portscan.NewImporter(db, resolver.New(*dnsServer, *dnsTimeout), inScope)
+  for _, file := range fs.Args() {
+    data, err := ioutil.ReadFile(file)
+    if err != nil {
+      return fmt.Errorf("failed to read %q: %v", file, err)
+    }
+    hosts, err := parse(data)
+    if err != nil {
+      return fmt.Errorf("failed to parse %q: %v", file, err)
+    }
+    stats, err := importer.Import(ctx, hosts, "import:"+*format)
+    if err != nil {
+      return fmt.Errorf("failed to import %q: %v", file, err)
+    }
+    log.Printf("Imported %q: %d hosts, %d unmapped, %d out of scope hostnames, %d new subdomains, %d new ports", file, stats.Hosts, stats.Unmapped, stats.OutOfScope, stats.NewSubdomains, stats.NewPorts)
+  }
+  return nil
+}
+
+// takeoversCommand lists stored takeovers or updates the status of one.
+func takeoversCommand(args []string) error {
+  fs := flag.NewFlagSet("takeovers", flag.ExitOnError)
+  status := fs.String("status", "", "only list takeovers with this status: open, verified, reported or fixed")
+  id := fs.Int64("id", 0, "takeover to update with -set")
+  set := fs.String("set", "", "status to set on the takeover given by -id")
+  fs.Parse(args)
+
+  db, err := storage.New(*dbName)
+  if err != nil {
+    return fmt.Errorf("failed to create sqlite client: %v", err)
+  }
+  if *set != "" {
+    return db.SetTakeoverStatus(*id, *set)
+  }
+  takeovers, err := db.Takeovers(*status)
+  if err != nil {
+    return err
+  }
+  for _, t := range takeovers {
+    fmt.Printf("#%d %s %s %s [%s, %s] first seen %s, last seen %s\n", t.ID, t.Subdomain, t.Kind, t.Service, t.Confidence, t.Status, t.FirstSeen.Format(time.RFC3339), t.LastSeen.Format(time.RFC3339))
+    if len(t.CNAMEs) > 0 {
+      fmt.Printf("\tCNAME: %s\n", strings.Join(t.CNAMEs, " -> "))
+    }
+    for _, record := range t.Records {
+      fmt.Printf("\t%s\n", record)
+    }
+    if t.URL != "" {
+      fmt.Printf("\tResponse: %s [%d] matched %q\n", t.URL, t.StatusCode, t.Fingerprint)
+    }
+  }
+  return nil
+}
+
+// fingerprintsCommand validates the takeover fingerprints and, with the test
+// subcommand, runs them against recorded sample responses.
+func fingerprintsCommand(args []string) error {
+  if len(args) == 0 || (args[0] != "validate" && args[0] != "test") {
+    return fmt.Errorf("usage: fingerprints validate|test [-samples dir]")
+  }
+  fs := flag.NewFlagSet("fingerprints "+args[0], flag.ExitOnError)
+  samples := fs.String("samples", "samples/takeover", "directory of JSON sample responses to test the fingerprints against")
+  fs.Parse(args[1:])
+
+  fps, err := takeover.LoadFingerprints(*fingerprints)
+  if err != nil {
+    return err
+  }
+  fmt.Printf("%d fingerprints in %s are valid\n", len(fps), *fingerprints)
+  if args[0] == "validate" {
+    return nil
+  }
+
+  results, err := takeover.TestSamples(fps, *samples)
+  if err != nil {
+    return err
+  }
+  failed := 0
+  for _, r := range results {
+    got := "no match"
+    if r.Result != nil {
+      got = fmt.Sprintf("%s (%s)", r.Result.Service, r.Result.Confidence)
+    }
+    want := r.Sample.Expect
+    if want == "" {
+      want = "no match"
+    }
+    if r.Passed {
+      fmt.Printf("PASS %s: %s\n", r.Sample.Name, got)
+      continue
+    }
+    failed++
+    fmt.Printf("FAIL %s: got %s, want %s\n", r.Sample.Name, got, want)
+  }
+  if failed > 0 {
+    return fmt.Errorf("%d of %d samples failed", failed, len(results))
+  }
+  fmt.Printf("%d samples passed\n", len(results))
+  return nil
+}
+
+// reportCommand renders stored results into a report file.
+func reportCommand(ctx context.Context, args []string) error {
+  if len(args) == 0 || args[0] != "gallery" {
+    return fmt.Errorf("usage: report gallery [-group domain|cluster|program] [-domain domain] [-out file]")
+  }
+  fs := flag.NewFlagSet("report gallery", flag.ExitOnError)
+  group := fs.String("group", report.GroupDomain, "group screenshots by domain, cluster or bug bounty program")
+  domain := fs.String("domain", "", "only include subdomains of this domain")
+  out := fs.String("out", "gallery.html", "file to write the gallery to")
+  fs.Parse(args[1:])
+
+  db, err := storage.New(*dbName)
+  if err != nil {
+    return fmt.Errorf("failed to create sqlite client: %v", err)
+  }
+  store, err := newScreenshotStore()
+  if err != nil {
+    return fmt.Errorf("failed to create screenshot store: %v", err)
+  }
+  opts := &report.GalleryOptions{Group: *group, Domain: *domain}
+  if *group == report.GroupProgram {
+    if opts.Programs, err = fetchPrograms(); err != nil {
+      return err
+    }
+  }
+  f, err := os.Create(*out)
+  if err != nil {
+    return fmt.Errorf("failed to create gallery file: %v", err)
+  }
+  defer f.Close()
+  if err := report.Gallery(ctx, db, store, f, opts); err != nil {
+    return err
+  }
+  log.Printf("Wrote gallery to %s", *out)
+  return f.Close()
+}
+
+// faviconsCommand lists every host serving a favicon with the hash.
+func faviconsCommand(args []string) error {
+  fs := flag.NewFlagSet("favicons", flag.ExitOnError)
+  hash := fs.String("hash", "", "mmh3 (as searched on Shodan with http.favicon.hash) or sha256 favicon hash")
+  fs.Parse(args)
+  if *hash == "" {
+    return fmt.Errorf("-hash is required")
+  }
+
+  db, err := storage.New(*dbName)
+  if err != nil {
+    return fmt.Errorf("failed to create sqlite client: %v", err)
+  }
+  favicons, err := db.SearchFavicons(*hash)
+  if err != nil {
+    return err
+  }
+  for _, f := range favicons {
+    fmt.Printf("%s:%d %s mmh3 %d sha256 %s", f.Subdomain, f.Port, f.URL, f.MMH3, f.SHA256)
+    if f.Product != "" {
+      fmt.Printf(" (%s)", f.Product)
+    }
+    fmt.Println()
+  }
+  return nil
+}
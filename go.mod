@@ -9,10 +9,10 @@ require (
 	github.com/chromedp/chromedp v0.5.3
 	github.com/domainr/whois v0.0.0-20200908173059-77846ed923f4 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/haccer/available v0.0.0-20180624175330-521f55248d4f // indirect
-	github.com/haccer/subjack v0.0.0-20190731105901-b800ca47290a
+	github.com/haccer/available v0.0.0-20180624175330-521f55248d4f
 	github.com/jmoiron/jsonq v0.0.0-20150511023944-e874b168d07e // indirect
 	github.com/mattn/go-sqlite3 v1.14.2
+	github.com/miekg/dns v1.1.31
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/slack-go/slack v0.6.6
 	github.com/valyala/fasthttp v1.16.0 // indirect
@@ -0,0 +1,199 @@
+package httpprobe
+
+import (
+  "bytes"
+  "context"
+  "encoding/base64"
+  "encoding/binary"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "math/bits"
+  "net/http"
+  "net/url"
+  "regexp"
+  "strconv"
+  "strings"
+
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+var (
+  linkRegex = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+  relRegex = regexp.MustCompile(`(?is)\brel\s*=\s*["']?([^"'>]*)`)
+  hrefRegex = regexp.MustCompile(`(?is)\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// FaviconDB maps favicon hashes, either mmh3 or sha256, to the products
+// serving them.
+type FaviconDB map[string]string
+
+// LoadFaviconDB reads a JSON object of favicon hashes to product names, e.g.
+// {"81586312": "Jenkins"}.
+func LoadFaviconDB(path string) (FaviconDB, error) {
+  data, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, fmt.Errorf("failed to read favicon db: %v", err)
+  }
+  db := FaviconDB{}
+  if err := json.Unmarshal(data, &db); err != nil {
+    return nil, fmt.Errorf("failed to parse favicon db json: %v", err)
+  }
+  return db, nil
+}
+
+// Product returns the product serving the favicon, if known.
+func (db FaviconDB) Product(favicon *storage.Favicon) string {
+  if product, ok := db[strconv.Itoa(int(favicon.MMH3))]; ok {
+    return product
+  }
+  return db[favicon.SHA256]
+}
+
+// favicons fetches /favicon.ico of the probed URL and the icons the page links
+// to and returns the ones that were found. Icons on other hosts, e.g. a login
+// page redirected to or a CDN, are skipped since they don't identify the port.
+func (c *Client) favicons(ctx context.Context, response *storage.HTTPResponse) []*storage.Favicon {
+  probed, err := url.Parse(response.URL)
+  if err != nil {
+    return nil
+  }
+  final, err := url.Parse(response.FinalURL)
+  if err != nil {
+    return nil
+  }
+  urls := []string{}
+  seen := map[string]bool{}
+  add := func(base *url.URL, ref string) {
+    u, err := base.Parse(strings.TrimSpace(ref))
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !strings.EqualFold(u.Hostname(), response.Subdomain) || seen[u.String()] {
+      return
+    }
+    seen[u.String()] = true
+    urls = append(urls, u.String())
+  }
+  add(probed, "/favicon.ico")
+  // Links are relative to the page they're on.
+  for _, link := range linkRegex.FindAllString(string(response.Body), -1) {
+    rel := relRegex.FindStringSubmatch(link)
+    if rel == nil || !isIconRel(rel[1]) {
+      continue
+    }
+    if href := hrefRegex.FindStringSubmatch(link); href != nil {
+      add(final, href[1]+href[2]+href[3])
+    }
+  }
+
+  favicons := []*storage.Favicon{}
+  for _, u := range urls {
+    data, err := c.fetchFavicon(ctx, u)
+    if err != nil || len(data) == 0 {
+      continue
+    }
+    favicon := &storage.Favicon{
+      Subdomain: response.Subdomain,
+      Port: response.Port,
+      URL: u,
+      MMH3: MMH3(data),
+      SHA256: sha256Hex(data),
+    }
+    favicon.Product = c.knownFavicons.Product(favicon)
+    favicons = append(favicons, favicon)
+  }
+  return favicons
+}
+
+// isIconRel reports whether a link's rel is an icon e.g. "shortcut icon".
+func isIconRel(rel string) bool {
+  for _, value := range strings.Fields(strings.ToLower(rel)) {
+    if value == "icon" || value == "apple-touch-icon" {
+      return true
+    }
+  }
+  return false
+}
+
+// fetchFavicon returns the body of a successful response that isn't an HTML
+// page, which many servers send for any path.
+func (c *Client) fetchFavicon(ctx context.Context, u string) ([]byte, error) {
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+  if err != nil {
+    return nil, fmt.Errorf("failed to create request: %v", err)
+  }
+  res, err := c.http.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer res.Body.Close()
+  if res.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("favicon %s returned %s", u, res.Status)
+  }
+  data, err := ioutil.ReadAll(io.LimitReader(res.Body, c.maxBytes))
+  if err != nil {
+    return nil, fmt.Errorf("failed to read favicon %s: %v", u, err)
+  }
+  if strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") || bytes.HasPrefix(bytes.TrimSpace(bytes.ToLower(data)), []byte("<!doctype html")) {
+    return nil, fmt.Errorf("favicon %s is an html page", u)
+  }
+  return data, nil
+}
+
+// MMH3 returns the favicon hash Shodan uses: the signed 32-bit MurmurHash3 of
+// the image base64 encoded with a newline every 76 characters and at the end,
+// as Python's base64.encodebytes does.
+func MMH3(data []byte) int32 {
+  // encodebytes(b"") is b"", with no trailing newline.
+  if len(data) == 0 {
+    return int32(murmur3(nil, 0))
+  }
+  encoded := base64.StdEncoding.EncodeToString(data)
+  var buf bytes.Buffer
+  for len(encoded) > 76 {
+    buf.WriteString(encoded[:76])
+    buf.WriteByte('\n')
+    encoded = encoded[76:]
+  }
+  buf.WriteString(encoded)
+  buf.WriteByte('\n')
+  return int32(murmur3(buf.Bytes(), 0))
+}
+
+// murmur3 returns the 32-bit x86 MurmurHash3 of data.
+func murmur3(data []byte, seed uint32) uint32 {
+  const c1, c2 = 0xcc9e2d51, 0x1b873593
+  h := seed
+  n := len(data) / 4
+  for i := 0; i < n; i++ {
+    k := binary.LittleEndian.Uint32(data[i*4:])
+    k *= c1
+    k = bits.RotateLeft32(k, 15)
+    k *= c2
+    h ^= k
+    h = bits.RotateLeft32(h, 13)
+    h = h*5 + 0xe6546b64
+  }
+  tail := data[n*4:]
+  var k uint32
+  switch len(tail) {
+  case 3:
+    k ^= uint32(tail[2]) << 16
+    fallthrough
+  case 2:
+    k ^= uint32(tail[1]) << 8
+    fallthrough
+  case 1:
+    k ^= uint32(tail[0])
+    k *= c1
+    k = bits.RotateLeft32(k, 15)
+    k *= c2
+    h ^= k
+  }
+  h ^= uint32(len(data))
+  h ^= h >> 16
+  h *= 0x85ebca6b
+  h ^= h >> 13
+  h *= 0xc2b2ae35
+  h ^= h >> 16
+  return h
+}
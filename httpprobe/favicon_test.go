@@ -0,0 +1,108 @@
+package httpprobe
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "reflect"
+  "sort"
+  "testing"
+  "time"
+
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+func TestMurmur3(t *testing.T) {
+  tests := []struct {
+    data string
+    seed uint32
+    want uint32
+  }{
+    {data: "", seed: 0, want: 0},
+    {data: "", seed: 1, want: 0x514e28b7},
+    {data: "", seed: 0xffffffff, want: 0x81f16f39},
+    {data: "hello", seed: 0, want: 613153351},
+    // Python mmh3.hash("foo") is -156908512 signed.
+    {data: "foo", seed: 0, want: 0xf6a5c420},
+    {data: "The quick brown fox jumps over the lazy dog", seed: 0, want: 0x2e4ff723},
+  }
+  for _, tt := range tests {
+    if got := murmur3([]byte(tt.data), tt.seed); got != tt.want {
+      t.Errorf("murmur3(%q, %d) = %#x, want %#x", tt.data, tt.seed, got, tt.want)
+    }
+  }
+}
+
+func TestMMH3(t *testing.T) {
+  data := make([]byte, 100)
+  for i := range data {
+    data[i] = byte(i)
+  }
+  tests := []struct {
+    name string
+    data []byte
+    // Python's base64.encodebytes of data, which Shodan hashes.
+    encoded string
+  }{
+    {name: "empty", data: []byte{}, encoded: ""},
+    {name: "short", data: []byte("foo"), encoded: "Zm9v\n"},
+    {
+      name: "wrapped at 76 characters",
+      data: data,
+      encoded: "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8gISIjJCUmJygpKissLS4vMDEyMzQ1Njc4\nOTo7PD0+P0BBQkNERUZHSElKS0xNTk9QUVJTVFVWV1hZWltcXV5fYGFiYw==\n",
+    },
+  }
+  for _, tt := range tests {
+    want := int32(murmur3([]byte(tt.encoded), 0))
+    if got := MMH3(tt.data); got != want {
+      t.Errorf("MMH3(%s) = %d, want %d", tt.name, got, want)
+    }
+  }
+}
+
+func TestFavicons(t *testing.T) {
+  icon := []byte("\x00\x00\x01\x00icon")
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    switch r.URL.Path {
+    case "/favicon.ico", "/static/icon.png":
+      w.Header().Set("Content-Type", "image/x-icon")
+      w.Write(icon)
+    case "/html.png":
+      w.Header().Set("Content-Type", "text/html")
+      w.Write([]byte("<!doctype html><html></html>"))
+    default:
+      http.NotFound(w, r)
+    }
+  }))
+  defer server.Close()
+  host, _ := url.Parse(server.URL)
+
+  c := New(nil, 5*time.Second, 1<<20, FaviconDB{})
+  response := &storage.HTTPResponse{
+    Subdomain: host.Hostname(),
+    Port: 80,
+    URL: server.URL,
+    FinalURL: server.URL + "/app/",
+    Body: []byte(`<html><head>
+<link rel="shortcut icon" href="../static/icon.png">
+<link rel="apple-touch-icon" href="/html.png">
+<link rel="icon" href="/missing.png">
+<link rel="stylesheet" href="/style.css">
+<link rel="icon" href="https://cdn.example.net/icon.png">
+</head></html>`),
+  }
+  favicons := c.favicons(context.Background(), response)
+  got := []string{}
+  for _, favicon := range favicons {
+    got = append(got, favicon.URL)
+    if favicon.MMH3 != MMH3(icon) || favicon.SHA256 != sha256Hex(icon) {
+      t.Errorf("favicon %s hashed to %d %s, want %d %s", favicon.URL, favicon.MMH3, favicon.SHA256, MMH3(icon), sha256Hex(icon))
+    }
+  }
+  sort.Strings(got)
+  want := []string{server.URL + "/favicon.ico", server.URL + "/static/icon.png"}
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("favicons() fetched %q, want %q", got, want)
+  }
+}
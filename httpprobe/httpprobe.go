@@ -0,0 +1,174 @@
+// Package httpprobe finds web servers on open ports and records what they
+// respond with.
+package httpprobe
+
+import (
+  "context"
+  "crypto/sha256"
+  "crypto/tls"
+  "encoding/hex"
+  "fmt"
+  "html"
+  "io"
+  "io/ioutil"
+  "net"
+  "net/http"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// Maximum number of redirects followed.
+const maxRedirects = 10
+
+var titleRegex = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// Client holds the db dependency.
+type Client struct {
+  db *storage.Client
+  http *http.Client
+  // Maximum number of body bytes read per response.
+  maxBytes int64
+  // Favicons are fetched unless this is nil.
+  knownFavicons FaviconDB
+}
+
+// New returns a new probe client. Favicons of web servers are fetched and
+// matched against knownFavicons, unless it is nil.
+func New(db *storage.Client, timeout time.Duration, maxBytes int64, knownFavicons FaviconDB) *Client {
+  return &Client{
+    db: db,
+    maxBytes: maxBytes,
+    knownFavicons: knownFavicons,
+    http: &http.Client{
+      Timeout: timeout,
+      Transport: &http.Transport{
+        Proxy: http.ProxyFromEnvironment,
+        DialContext: (&net.Dialer{Timeout: timeout}).DialContext,
+        TLSHandshakeTimeout: timeout,
+        // Hosts are probed by name, so certificates of internal or expired
+        // services would otherwise hide them.
+        TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+        DisableKeepAlives: true,
+      },
+      CheckRedirect: func(req *http.Request, via []*http.Request) error {
+        if len(via) >= maxRedirects {
+          return http.ErrUseLastResponse
+        }
+        return nil
+      },
+    },
+  }
+}
+
+// Probe tries HTTPS and then HTTP on each open TCP port of the subdomain and
+// stores the first response, along with the favicons of the web server. Plain
+// HTTP requests to TLS ports often get an error page back, so HTTPS goes
+// first.
+func (c *Client) Probe(ctx context.Context, subdomain *storage.Subdomain) error {
+  for _, port := range subdomain.Ports {
+    if port.Protocol != "tcp" {
+      continue
+    }
+    for _, scheme := range []string{"https", "http"} {
+      url := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(port.Subdomain, strconv.Itoa(port.Number)))
+      response, err := c.fetch(ctx, url)
+      if err != nil {
+        continue
+      }
+      response.Subdomain = port.Subdomain
+      response.Port = port.Number
+      if err := c.db.InsertHTTPResponse(response); err != nil {
+        return err
+      }
+      port.HTTP = response
+      if c.knownFavicons != nil {
+        port.Favicons = c.favicons(ctx, response)
+        if err := c.db.ReplaceFavicons(port, port.Favicons); err != nil {
+          return err
+        }
+      }
+      break
+    }
+  }
+  return nil
+}
+
+// fetch requests the url, following redirects, and records the final
+// response.
+func (c *Client) fetch(ctx context.Context, url string) (*storage.HTTPResponse, error) {
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+  if err != nil {
+    return nil, fmt.Errorf("failed to create request: %v", err)
+  }
+  res, err := c.http.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer res.Body.Close()
+  body, err := ioutil.ReadAll(io.LimitReader(res.Body, c.maxBytes))
+  if err != nil {
+    return nil, fmt.Errorf("failed to read response of %s: %v", url, err)
+  }
+
+  response := &storage.HTTPResponse{
+    URL: url,
+    StatusCode: res.StatusCode,
+    FinalURL: res.Request.URL.String(),
+    Redirects: redirects(res),
+    Title: Title(body),
+    ContentLength: res.ContentLength,
+    Server: res.Header.Get("Server"),
+    Headers: headers(res.Header),
+    BodyHash: sha256Hex(body),
+    Body: body,
+  }
+  if response.ContentLength < 0 {
+    response.ContentLength = int64(len(body))
+  }
+  return response, nil
+}
+
+// Title returns the text of the page's title element.
+func Title(body []byte) string {
+  match := titleRegex.FindSubmatch(body)
+  if match == nil {
+    return ""
+  }
+  return strings.Join(strings.Fields(html.UnescapeString(string(match[1]))), " ")
+}
+
+// redirects returns the URLs redirected to on the way to the response, in
+// order.
+func redirects(res *http.Response) []string {
+  urls := []string{}
+  for req := res.Request; req.Response != nil; req = req.Response.Request {
+    urls = append([]string{req.URL.String()}, urls...)
+  }
+  return urls
+}
+
+// headers formats the headers one per line, sorted by name.
+func headers(header http.Header) string {
+  names := make([]string, 0, len(header))
+  for name := range header {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  lines := []string{}
+  for _, name := range names {
+    for _, value := range header[name] {
+      lines = append(lines, fmt.Sprintf("%s: %s", name, value))
+    }
+  }
+  return strings.Join(lines, "\n")
+}
+
+func sha256Hex(data []byte) string {
+  sum := sha256.Sum256(data)
+  return hex.EncodeToString(sum[:])
+}
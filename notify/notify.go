@@ -2,13 +2,19 @@
 package notify
 
 import (
+  "bytes"
   "fmt"
   "os"
+  "strings"
+  "time"
 
   "github.com/slack-go/slack"
   "github.com/dlegs/bounty-hunter/storage"
 )
 
+// Maximum number of hosts listed in a cluster alert.
+const maxClusterHosts = 20
+
 // Client holds the slack client dependency and channels the bot is part of.
 type Client struct {
   slack *slack.Client
@@ -63,6 +69,38 @@ func(c *Client) NotifyPort(port *storage.Port) error {
 // takeover has been found on a subdomain.
 func (c *Client) NotifyTakeover(subdomain *storage.Subdomain) error {
   msg := fmt.Sprintf("New subdomain takeover on host: %s\n\tService: %s", subdomain.Name, subdomain.Takeover)
+  msg += takeoverEvidence(subdomain.TakeoverEvidence)
+  return c.sendMsg(msg)
+}
+
+// takeoverEvidence formats the evidence of a takeover for a message.
+func takeoverEvidence(t *storage.Takeover) string {
+  if t == nil {
+    return ""
+  }
+  msg := fmt.Sprintf("\n\tConfidence: %s\n\tStatus: %s (takeover #%d)", t.Confidence, t.Status, t.ID)
+  if len(t.CNAMEs) > 0 {
+    msg += fmt.Sprintf("\n\tCNAME: %s", strings.Join(t.CNAMEs, " -> "))
+  }
+  for _, record := range t.Records {
+    msg += fmt.Sprintf("\n\t%s", record)
+  }
+  if t.URL != "" {
+    msg += fmt.Sprintf("\n\tResponse: %s [%d]", t.URL, t.StatusCode)
+  }
+  if t.Excerpt != "" {
+    msg += fmt.Sprintf("\n```%s```", t.Excerpt)
+  }
+  return msg
+}
+
+// NotifyFinding sends a slack message to available channels that a finding
+// has been made. High severity findings mention the whole channel.
+func (c *Client) NotifyFinding(finding *storage.Finding) error {
+  msg := fmt.Sprintf("New %s finding on %s [%s]\n\t%s", finding.Kind, finding.Target, finding.Severity, finding.Detail)
+  if finding.Severity == storage.SeverityHigh {
+    msg = "<!channel> " + msg
+  }
   return c.sendMsg(msg)
 }
 
@@ -71,21 +109,56 @@ func (c *Client) NotifyTakeover(subdomain *storage.Subdomain) error {
 // TODO: format a nicer message
 func (c *Client) NotifySubdomain(subdomain *storage.Subdomain) error {
   msg := fmt.Sprintf("New subdomain found: %s", subdomain.Name)
+  for _, source := range subdomain.Sources {
+    msg += fmt.Sprintf("\n\tSource: %s (first seen %s)", source.Name, source.FirstSeen.Format(time.RFC3339))
+  }
+  if subdomain.CDN != "" {
+    msg += fmt.Sprintf("\n\tCDN: %s", subdomain.CDN)
+  }
   for _, port := range subdomain.Ports {
     msg += fmt.Sprintf("\n\tPort: %d/%s %s %s %s", port.Number, port.Protocol, port.Service, port.Product, port.Version)
+    if r := port.HTTP; r != nil {
+      msg += fmt.Sprintf("\n\t\tHTTP: %s [%d] %q", r.URL, r.StatusCode, r.Title)
+      if len(r.Redirects) > 0 {
+        msg += fmt.Sprintf(" -> %s", r.FinalURL)
+      }
+      if r.Server != "" {
+        msg += fmt.Sprintf(" (%s)", r.Server)
+      }
+    }
+    if len(port.Technologies) > 0 {
+      msg += fmt.Sprintf("\n\t\tTech: %s", technologies(port.Technologies))
+    }
+    for _, f := range port.Favicons {
+      msg += fmt.Sprintf("\n\t\tFavicon: mmh3 %d", f.MMH3)
+      if f.Product != "" {
+        msg += fmt.Sprintf(" (%s)", f.Product)
+      }
+    }
+    if port.Screenshot != nil && port.Screenshot.Cluster != nil && port.Screenshot.Cluster.Size > 1 {
+      msg += fmt.Sprintf("\n\t\tLooks like %d other hosts: %s", port.Screenshot.Cluster.Size-1, clusterName(port.Screenshot.Cluster))
+    }
   }
   if subdomain.Takeover != "" {
-    msg += fmt.Sprintf("Vulnerable to subdomain takeover: %s", subdomain.Takeover)
+    msg += fmt.Sprintf("\nVulnerable to subdomain takeover: %s", subdomain.Takeover)
+    msg += takeoverEvidence(subdomain.TakeoverEvidence)
   }
   if err := c.sendMsg(msg); err != nil {
     return err
   }
   for _, port := range subdomain.Ports {
-    if port.Screenshot == "" {
+    if port.Screenshot == nil {
+      continue
+    }
+    // Pages looking like ones already seen are sent with their cluster's
+    // next alert instead.
+    if cluster := port.Screenshot.Cluster; cluster != nil && cluster.Size > 1 {
       continue
     }
     if _, err := c.slack.UploadFile(slack.FileUploadParameters{
-      File: port.Screenshot,
+      Reader: bytes.NewReader(port.Screenshot.Image),
+      Filename: fmt.Sprintf("%s-%d.png", port.Subdomain, port.Number),
+      Title: port.Screenshot.URL,
       Channels: c.channels,
     }); err != nil {
       return fmt.Errorf("failed to upload screnshot of port %v: %v", port, err)
@@ -94,6 +167,75 @@ func (c *Client) NotifySubdomain(subdomain *storage.Subdomain) error {
   return nil
 }
 
+// NotifyVisualChange sends a slack message with the before and after images of
+// a page that changed visually between two screenshots.
+func (c *Client) NotifyVisualChange(before, after *storage.Screenshot) error {
+  msg := fmt.Sprintf("Page changed visually: %s\n\tDistance: %d\n\tBefore: %s\n\tAfter: %s", after.URL, after.Distance, before.TakenAt.Format(time.RFC3339), after.TakenAt.Format(time.RFC3339))
+  if err := c.sendMsg(msg); err != nil {
+    return err
+  }
+  for _, s := range []*storage.Screenshot{before, after} {
+    if _, err := c.slack.UploadFile(slack.FileUploadParameters{
+      Reader: bytes.NewReader(s.Image),
+      Filename: fmt.Sprintf("%s-%d-%s.png", s.Subdomain, s.Port, s.TakenAt.Format("20060102T150405")),
+      Title: fmt.Sprintf("%s at %s", s.URL, s.TakenAt.Format(time.RFC3339)),
+      Channels: c.channels,
+    }); err != nil {
+      return fmt.Errorf("failed to upload screenshot of %s: %v", s.URL, err)
+    }
+  }
+  return nil
+}
+
+// NotifyCluster sends a single slack message for the screenshots added to a
+// cluster, with the image representing it.
+func (c *Client) NotifyCluster(cluster *storage.Cluster, representative *storage.Screenshot, added []*storage.Screenshot) error {
+  msg := fmt.Sprintf("%d hosts showing %s", cluster.Size, clusterName(cluster))
+  if len(added) > 0 {
+    msg += fmt.Sprintf("\n\t%d new:", len(added))
+  }
+  for i, s := range added {
+    if i == maxClusterHosts {
+      msg += fmt.Sprintf("\n\t... and %d more", len(added)-i)
+      break
+    }
+    msg += fmt.Sprintf("\n\t%s", s.URL)
+  }
+  if err := c.sendMsg(msg); err != nil {
+    return err
+  }
+  if _, err := c.slack.UploadFile(slack.FileUploadParameters{
+    Reader: bytes.NewReader(representative.Image),
+    Filename: fmt.Sprintf("cluster-%d.png", cluster.ID),
+    Title: fmt.Sprintf("Cluster %d: %s", cluster.ID, representative.URL),
+    Channels: c.channels,
+  }); err != nil {
+    return fmt.Errorf("failed to upload screenshot of cluster %d: %v", cluster.ID, err)
+  }
+  return nil
+}
+
+// technologies lists technologies with their versions.
+func technologies(technologies []*storage.Technology) string {
+  names := []string{}
+  for _, t := range technologies {
+    if t.Version != "" {
+      names = append(names, fmt.Sprintf("%s %s", t.Name, t.Version))
+      continue
+    }
+    names = append(names, t.Name)
+  }
+  return strings.Join(names, ", ")
+}
+
+// clusterName describes a cluster by its page title.
+func clusterName(cluster *storage.Cluster) string {
+  if cluster.Title == "" {
+    return fmt.Sprintf("the same untitled page (cluster %d)", cluster.ID)
+  }
+  return fmt.Sprintf("%q (cluster %d)", cluster.Title, cluster.ID)
+}
+
 // sendMsg sends a string to all available slack channels.
 func(c *Client) sendMsg(msg string) error {
   for _, channel := range c.channels {
@@ -0,0 +1,276 @@
+// Package permute generates permutations of discovered subdomains to find
+// siblings that never show up in certificate transparency logs.
+package permute
+
+import (
+  "context"
+  "fmt"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+
+  "github.com/dlegs/bounty-hunter/resolver"
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+var (
+  // commonWords are mixed into every domain's word list.
+  commonWords = []string{"dev", "development", "stage", "staging", "stg", "test", "qa", "uat", "prod", "preprod", "internal", "admin", "api", "beta", "old", "new", "demo", "sandbox", "v1", "v2"}
+  numberRegex = regexp.MustCompile(`\d+`)
+)
+
+// Client holds db and resolver dependencies.
+type Client struct {
+  db *storage.Client
+  resolver *resolver.Client
+  // Maximum number of candidates resolved per domain on each run.
+  limit int
+  // Number of concurrent lookups.
+  workers int
+  mu sync.Mutex
+  running map[string]bool
+}
+
+// New returns a new permutation client.
+func New(db *storage.Client, resolver *resolver.Client, limit, workers int) *Client {
+  return &Client{
+    db: db,
+    resolver: resolver,
+    limit: limit,
+    workers: workers,
+    running: map[string]bool{},
+  }
+}
+
+// Permute generates permutations of the subdomain seeded with words from every
+// subdomain stored under the same domain, and returns the candidates that
+// resolve and aren't stored yet. Candidates resolving only to the addresses
+// of a wildcard record on their parent are dropped. Only one run per domain happens at a time, so
+// bursts of subdomains under the same domain are skipped rather than queued.
+func (c *Client) Permute(ctx context.Context, subdomain *storage.Subdomain) ([]string, error) {
+  if !c.start(subdomain.Domain) {
+    return nil, nil
+  }
+  defer c.stop(subdomain.Domain)
+
+  known, err := c.db.Subdomains(subdomain.Domain)
+  if err != nil {
+    return nil, fmt.Errorf("failed to fetch subdomains of %q: %v", subdomain.Domain, err)
+  }
+  seen := map[string]bool{}
+  words := map[string]bool{}
+  for _, w := range commonWords {
+    words[w] = true
+  }
+  for _, k := range known {
+    seen[k.Name] = true
+    for _, w := range tokenize(prefix(k.Name, k.Domain)) {
+      words[w] = true
+    }
+  }
+
+  candidates := []string{}
+  for _, candidate := range Generate(subdomain.Name, subdomain.Domain, sorted(words)) {
+    if seen[candidate] {
+      continue
+    }
+    candidates = append(candidates, candidate)
+    if len(candidates) >= c.limit {
+      break
+    }
+  }
+  wildcards := map[string]map[string]bool{}
+  for _, candidate := range candidates {
+    parent := parent(candidate)
+    if _, ok := wildcards[parent]; ok {
+      continue
+    }
+    if wildcards[parent], err = c.resolver.WildcardIPs(ctx, parent); err != nil {
+      return nil, err
+    }
+  }
+  return c.resolve(ctx, candidates, wildcards), nil
+}
+
+// resolve looks up the candidates concurrently and returns those that resolve
+// to more than their parent's wildcard addresses.
+func (c *Client) resolve(ctx context.Context, candidates []string, wildcards map[string]map[string]bool) []string {
+  namec := make(chan string)
+  hitc := make(chan string)
+  var wg sync.WaitGroup
+  for i := 0; i < c.workers; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for name := range namec {
+        ips, err := c.resolver.LookupIP(ctx, name)
+        if err != nil || len(ips) == 0 || resolver.OnlyWildcard(ips, wildcards[parent(name)]) {
+          continue
+        }
+        hitc <- name
+      }
+    }()
+  }
+  go func() {
+    for _, candidate := range candidates {
+      namec <- candidate
+    }
+    close(namec)
+    wg.Wait()
+    close(hitc)
+  }()
+
+  hits := []string{}
+  for hit := range hitc {
+    hits = append(hits, hit)
+  }
+  return hits
+}
+
+// start marks a domain as being permuted, returning false if it already is.
+func (c *Client) start(domain string) bool {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if c.running[domain] {
+    return false
+  }
+  c.running[domain] = true
+  return true
+}
+
+func (c *Client) stop(domain string) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  delete(c.running, domain)
+}
+
+// Generate returns altdns-style permutations of name under domain: word
+// insertion, word replacement, number increments and dash/dot swaps.
+func Generate(name, domain string, words []string) []string {
+  label := prefix(name, domain)
+  if label == "" {
+    return nil
+  }
+  suffix := "." + domain
+  seen := map[string]bool{label: true}
+  out := []string{}
+  add := func(candidate string) {
+    candidate = strings.Trim(candidate, ".-")
+    if candidate == "" || seen[candidate] || !valid(candidate) {
+      return
+    }
+    seen[candidate] = true
+    out = append(out, candidate+suffix)
+  }
+
+  // Number increments e.g. api2 -> api1, api3 and api -> api2.
+  if numberRegex.MatchString(label) {
+    for _, loc := range numberRegex.FindAllStringIndex(label, -1) {
+      n, err := strconv.Atoi(label[loc[0]:loc[1]])
+      if err != nil {
+        continue
+      }
+      for _, delta := range []int{-1, 1, 2} {
+        if n+delta < 0 {
+          continue
+        }
+        add(label[:loc[0]] + strconv.Itoa(n+delta) + label[loc[1]:])
+      }
+    }
+  } else {
+    first := strings.SplitN(label, ".", 2)
+    for _, n := range []string{"1", "2", "3"} {
+      rest := ""
+      if len(first) > 1 {
+        rest = "." + first[1]
+      }
+      add(first[0] + n + rest)
+    }
+  }
+
+  // Dash/dot swaps e.g. api-staging <-> api.staging.
+  add(strings.ReplaceAll(label, "-", "."))
+  add(strings.ReplaceAll(label, ".", "-"))
+
+  tokens := tokenize(label)
+  for _, word := range words {
+    // Word insertion before and after the leftmost label.
+    add(word + "-" + label)
+    add(label + "-" + word)
+    add(word + "." + label)
+    // Word replacement e.g. api-staging -> api-dev.
+    for _, token := range tokens {
+      if token == word {
+        continue
+      }
+      add(replaceToken(label, token, word))
+    }
+  }
+  return out
+}
+
+// prefix returns the part of name in front of domain.
+func prefix(name, domain string) string {
+  return strings.TrimSuffix(strings.TrimSuffix(name, domain), ".")
+}
+
+// parent returns the name without its leftmost label.
+func parent(name string) string {
+  if i := strings.Index(name, "."); i >= 0 {
+    return name[i+1:]
+  }
+  return name
+}
+
+// tokenize splits a subdomain prefix into its words.
+func tokenize(label string) []string {
+  return strings.FieldsFunc(label, func(r rune) bool {
+    return r == '.' || r == '-' || r == '_'
+  })
+}
+
+// replaceToken replaces the first whole-word occurrence of token in label.
+func replaceToken(label, token, word string) string {
+  parts := strings.FieldsFunc(label, func(r rune) bool {
+    return r == '.' || r == '-'
+  })
+  seps := strings.FieldsFunc(label, func(r rune) bool {
+    return r != '.' && r != '-'
+  })
+  for i, part := range parts {
+    if part == token {
+      parts[i] = word
+      break
+    }
+  }
+  out := parts[0]
+  for i, part := range parts[1:] {
+    sep := "."
+    if i < len(seps) {
+      sep = seps[i]
+    }
+    out += sep + part
+  }
+  return out
+}
+
+// valid reports whether every label is a well formed hostname label.
+func valid(name string) bool {
+  for _, label := range strings.Split(name, ".") {
+    if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") || strings.Contains(label, "*") {
+      return false
+    }
+  }
+  return true
+}
+
+func sorted(set map[string]bool) []string {
+  out := make([]string, 0, len(set))
+  for k := range set {
+    out = append(out, k)
+  }
+  sort.Strings(out)
+  return out
+}
@@ -0,0 +1,80 @@
+package portscan
+
+import (
+  "sync"
+  "time"
+
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// cacheEntry holds the result of scanning an IP with a profile.
+type cacheEntry struct {
+  ports []*storage.Port
+  expires time.Time
+  // Closed once the scan has finished.
+  done chan struct{}
+}
+
+// ipCache deduplicates scans of hostnames resolving to the same IP, so an IP is
+// scanned once per ttl with each profile.
+type ipCache struct {
+  ttl time.Duration
+  mu sync.Mutex
+  entries map[string]*cacheEntry
+}
+
+func newIPCache(ttl time.Duration) *ipCache {
+  return &ipCache{
+    ttl: ttl,
+    entries: map[string]*cacheEntry{},
+  }
+}
+
+// scan returns the cached ports of the ip for host, calling scanFunc if the ip
+// hasn't been scanned within the ttl. Concurrent scans of the same ip wait for
+// the first one to finish rather than scanning again.
+func (c *ipCache) scan(ip, profile, host string, scanFunc func() []*storage.Port) []*storage.Port {
+  if c.ttl <= 0 || ip == "" {
+    return scanFunc()
+  }
+  key := ip + "/" + profile
+  c.mu.Lock()
+  entry, ok := c.entries[key]
+  if ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+    c.mu.Unlock()
+    <-entry.done
+    return fanOut(entry.ports, host)
+  }
+  entry = &cacheEntry{done: make(chan struct{})}
+  c.entries[key] = entry
+  c.mu.Unlock()
+
+  entry.ports = scanFunc()
+  c.mu.Lock()
+  entry.expires = time.Now().Add(c.ttl)
+  c.evict()
+  c.mu.Unlock()
+  close(entry.done)
+  return fanOut(entry.ports, host)
+}
+
+// evict removes expired entries. Must be called with mu held.
+func (c *ipCache) evict() {
+  now := time.Now()
+  for key, entry := range c.entries {
+    if !entry.expires.IsZero() && now.After(entry.expires) {
+      delete(c.entries, key)
+    }
+  }
+}
+
+// fanOut copies ports found on an ip so they belong to host.
+func fanOut(ports []*storage.Port, host string) []*storage.Port {
+  out := make([]*storage.Port, 0, len(ports))
+  for _, p := range ports {
+    port := *p
+    port.Subdomain = host
+    out = append(out, &port)
+  }
+  return out
+}
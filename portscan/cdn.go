@@ -0,0 +1,78 @@
+package portscan
+
+import (
+  "net"
+)
+
+// Policies for hosts behind a CDN.
+const (
+  // Scan CDN hosts like any other host.
+  CDNScan = "scan"
+  // Only scan web ports of CDN hosts.
+  CDNWeb = "web"
+  // Don't scan CDN hosts at all.
+  CDNSkip = "skip"
+)
+
+// cdnWebPorts are scanned on CDN hosts under the CDNWeb policy.
+const cdnWebPorts = "80,443,8080,8443"
+
+// cdnRanges are the published edge ranges of common CDNs.
+var cdnRanges = map[string][]string{
+  "cloudflare": {
+    "173.245.48.0/20", "103.21.244.0/22", "103.22.200.0/22", "103.31.4.0/22",
+    "141.101.64.0/18", "108.162.192.0/18", "190.93.240.0/20", "188.114.96.0/20",
+    "197.234.240.0/22", "198.41.128.0/17", "162.158.0.0/15", "104.16.0.0/13",
+    "104.24.0.0/14", "172.64.0.0/13", "131.0.72.0/22",
+  },
+  "fastly": {
+    "23.235.32.0/20", "43.249.72.0/22", "103.244.50.0/24", "103.245.222.0/23",
+    "103.245.224.0/24", "104.156.80.0/20", "140.248.64.0/18", "140.248.128.0/17",
+    "146.75.0.0/17", "151.101.0.0/16", "157.52.64.0/18", "167.82.0.0/17",
+    "167.82.128.0/20", "167.82.160.0/20", "167.82.224.0/20", "172.111.64.0/18",
+    "185.31.16.0/22", "199.27.72.0/21", "199.232.0.0/16",
+  },
+  "cloudfront": {
+    "13.32.0.0/15", "13.35.0.0/16", "13.224.0.0/14", "13.249.0.0/16",
+    "18.64.0.0/14", "18.154.0.0/15", "18.160.0.0/15", "18.164.0.0/15",
+    "18.238.0.0/15", "52.84.0.0/15", "54.182.0.0/16", "54.192.0.0/16",
+    "54.230.0.0/16", "54.239.128.0/18", "64.252.64.0/18", "65.8.0.0/16",
+    "65.9.0.0/17", "99.84.0.0/16", "99.86.0.0/16", "108.138.0.0/15",
+    "108.156.0.0/14", "143.204.0.0/16", "204.246.164.0/22", "205.251.249.0/24",
+    "216.137.32.0/19",
+  },
+  "akamai": {
+    "2.16.0.0/13", "23.0.0.0/12", "23.32.0.0/11", "23.64.0.0/14",
+    "23.192.0.0/11", "72.246.0.0/15", "88.221.0.0/16", "92.122.0.0/15",
+    "95.100.0.0/15", "96.6.0.0/15", "96.16.0.0/15", "104.64.0.0/10",
+    "184.24.0.0/13", "184.50.0.0/15", "184.84.0.0/14",
+  },
+}
+
+var cdnNets = parseRanges(cdnRanges)
+
+// CDN returns the name of the CDN the ip belongs to, or "" if none.
+func CDN(ip net.IP) string {
+  for provider, nets := range cdnNets {
+    for _, n := range nets {
+      if n.Contains(ip) {
+        return provider
+      }
+    }
+  }
+  return ""
+}
+
+func parseRanges(ranges map[string][]string) map[string][]*net.IPNet {
+  nets := map[string][]*net.IPNet{}
+  for provider, cidrs := range ranges {
+    for _, cidr := range cidrs {
+      _, n, err := net.ParseCIDR(cidr)
+      if err != nil {
+        panic(err)
+      }
+      nets[provider] = append(nets[provider], n)
+    }
+  }
+  return nets
+}
@@ -0,0 +1,167 @@
+package portscan
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "net"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// DefaultPorts are scanned by the connect scanner when no port list is given.
+var DefaultPorts = []int{21, 22, 23, 25, 53, 80, 81, 110, 111, 135, 139, 143, 443, 445, 465, 587, 993, 995, 1080, 1433, 1521, 2049, 2375, 2376, 3000, 3306, 3389, 4443, 5000, 5432, 5601, 5900, 5984, 6379, 7001, 8000, 8008, 8080, 8081, 8088, 8443, 8888, 9000, 9090, 9200, 9300, 9443, 10000, 11211, 27017}
+
+// services maps well known ports to the service names nmap would report, so
+// results of the connect scanner look the same to later stages.
+var services = map[int]string{
+  21: "ftp",
+  22: "ssh",
+  23: "telnet",
+  25: "smtp",
+  53: "domain",
+  80: "http",
+  81: "http",
+  110: "pop3",
+  111: "rpcbind",
+  135: "msrpc",
+  139: "netbios-ssn",
+  143: "imap",
+  443: "https",
+  445: "microsoft-ds",
+  465: "smtps",
+  587: "submission",
+  993: "imaps",
+  995: "pop3s",
+  1433: "ms-sql-s",
+  1521: "oracle",
+  2049: "nfs",
+  3000: "http",
+  3306: "mysql",
+  3389: "ms-wbt-server",
+  4443: "https",
+  5432: "postgresql",
+  5601: "http",
+  5900: "vnc",
+  5984: "http",
+  6379: "redis",
+  8000: "http",
+  8008: "http",
+  8080: "http",
+  8081: "http",
+  8088: "http",
+  8443: "https",
+  8888: "http",
+  9090: "http",
+  9200: "http",
+  9443: "https",
+  11211: "memcache",
+  27017: "mongodb",
+}
+
+// ParsePorts parses a port list such as "22,80,8000-8100".
+func ParsePorts(spec string) ([]int, error) {
+  seen := map[int]bool{}
+  ports := []int{}
+  for _, part := range strings.Split(spec, ",") {
+    part = strings.TrimSpace(part)
+    if part == "" {
+      continue
+    }
+    bounds := strings.SplitN(part, "-", 2)
+    low, err := strconv.Atoi(bounds[0])
+    if err != nil {
+      return nil, fmt.Errorf("invalid port %q: %v", part, err)
+    }
+    high := low
+    if len(bounds) == 2 {
+      if high, err = strconv.Atoi(bounds[1]); err != nil {
+        return nil, fmt.Errorf("invalid port range %q: %v", part, err)
+      }
+    }
+    if low < 1 || high > 65535 || low > high {
+      return nil, fmt.Errorf("invalid port range %q", part)
+    }
+    for port := low; port <= high; port++ {
+      if !seen[port] {
+        seen[port] = true
+        ports = append(ports, port)
+      }
+    }
+  }
+  return ports, nil
+}
+
+// connectScan attempts a full TCP connection to each port of the host and
+// returns the ports that accepted. The profile's port list is used if it has
+// one, otherwise the configured ports. UDP and top ports profiles are rejected
+// by Profiles.CheckScanner.
+func (c *Client) connectScan(ctx context.Context, host string, profile *Profile) []*storage.Port {
+  ports := c.opts.Ports
+  if profile.Ports != "" {
+    // Already validated when the profiles were loaded.
+    ports, _ = ParsePorts(profile.Ports)
+  }
+  if len(ports) == 0 {
+    ports = DefaultPorts
+  }
+
+  var tick <-chan time.Time
+  if c.opts.Rate > 0 {
+    ticker := time.NewTicker(time.Second / time.Duration(c.opts.Rate))
+    defer ticker.Stop()
+    tick = ticker.C
+  }
+
+  portc := make(chan int)
+  var mu sync.Mutex
+  var wg sync.WaitGroup
+  open := []*storage.Port{}
+  for i := 0; i < c.opts.Workers; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      dialer := net.Dialer{Timeout: c.opts.Timeout}
+      for port := range portc {
+        conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+        if err != nil {
+          continue
+        }
+        conn.Close()
+        mu.Lock()
+        open = append(open, &storage.Port{
+          Number: port,
+          Subdomain: host,
+          Protocol: "tcp",
+          Service: services[port],
+        })
+        mu.Unlock()
+      }
+    }()
+  }
+  for _, port := range ports {
+    if tick != nil {
+      <-tick
+    }
+    if ctx.Err() != nil {
+      break
+    }
+    portc <- port
+  }
+  close(portc)
+  wg.Wait()
+
+  sort.Slice(open, func(i, j int) bool {
+    return open[i].Number < open[j].Number
+  })
+  log.Printf("Host: %q", host)
+  for _, port := range open {
+    log.Printf("\tPort %d/%s [open] %s", port.Number, port.Protocol, port.Service)
+  }
+  return open
+}
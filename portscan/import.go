@@ -0,0 +1,306 @@
+package portscan
+
+import (
+  "bufio"
+  "bytes"
+  "context"
+  "encoding/json"
+  "fmt"
+  "log"
+  "strconv"
+  "strings"
+
+  "github.com/Ullaakut/nmap"
+  "golang.org/x/net/publicsuffix"
+  "github.com/dlegs/bounty-hunter/resolver"
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// Host is a scanned host read from historical scan output.
+type Host struct {
+  IP string
+  Hostnames []string
+  Ports []*storage.Port
+}
+
+// ParseNmapXML parses nmap -oX output. Only hostnames the scan was given are
+// kept, as reverse DNS names often belong to the hosting provider rather than
+// the target.
+func ParseNmapXML(data []byte) ([]*Host, error) {
+  run, err := nmap.Parse(data)
+  if err != nil {
+    return nil, fmt.Errorf("failed to parse nmap xml: %v", err)
+  }
+  hosts := []*Host{}
+  for _, h := range run.Hosts {
+    host := &Host{}
+    for _, address := range h.Addresses {
+      if address.AddrType == "ipv4" || address.AddrType == "ipv6" {
+        host.IP = address.Addr
+        break
+      }
+    }
+    for _, hostname := range h.Hostnames {
+      if hostname.Type != "user" {
+        continue
+      }
+      host.Hostnames = append(host.Hostnames, strings.ToLower(hostname.Name))
+    }
+    for _, p := range h.Ports {
+      if p.State.State != "open" {
+        continue
+      }
+      host.Ports = append(host.Ports, &storage.Port{
+        Number: int(p.ID),
+        Protocol: p.Protocol,
+        Service: p.Service.Name,
+        Product: p.Service.Product,
+        Version: p.Service.Version,
+      })
+    }
+    hosts = append(hosts, host)
+  }
+  return hosts, nil
+}
+
+// masscanRecord is a single host of masscan -oJ output.
+type masscanRecord struct {
+  IP string `json:"ip"`
+  Ports []struct {
+    Port int `json:"port"`
+    Proto string `json:"proto"`
+    Status string `json:"status"`
+    Service struct {
+      Name string `json:"name"`
+      Banner string `json:"banner"`
+    } `json:"service"`
+  } `json:"ports"`
+}
+
+// ParseMasscan parses masscan -oJ or -oL output.
+func ParseMasscan(data []byte) ([]*Host, error) {
+  byIP := map[string]*Host{}
+  hosts := []*Host{}
+  add := func(ip string, port *storage.Port) {
+    host, ok := byIP[ip]
+    if !ok {
+      host = &Host{IP: ip}
+      byIP[ip] = host
+      hosts = append(hosts, host)
+    }
+    if port.Service == "" {
+      port.Service = services[port.Number]
+    }
+    host.Ports = append(host.Ports, port)
+  }
+
+  trimmed := bytes.TrimSpace(data)
+  if bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")) {
+    // Older masscan versions leave a trailing comma before the closing
+    // bracket, so decode one record per line instead of the whole array.
+    scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+    scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+    for scanner.Scan() {
+      line := strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ",")
+      if !strings.HasPrefix(line, "{") {
+        continue
+      }
+      record := &masscanRecord{}
+      if err := json.Unmarshal([]byte(line), record); err != nil {
+        return nil, fmt.Errorf("failed to parse masscan json line %q: %v", line, err)
+      }
+      for _, p := range record.Ports {
+        if p.Status != "" && p.Status != "open" {
+          continue
+        }
+        add(record.IP, &storage.Port{
+          Number: p.Port,
+          Protocol: p.Proto,
+          Service: p.Service.Name,
+        })
+      }
+    }
+    return hosts, scanner.Err()
+  }
+
+  // List output lines look like "open tcp 80 192.0.2.1 1600000000".
+  scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+  for scanner.Scan() {
+    fields := strings.Fields(scanner.Text())
+    if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+      continue
+    }
+    if len(fields) < 4 {
+      return nil, fmt.Errorf("malformed masscan list line %q", scanner.Text())
+    }
+    if fields[0] != "open" {
+      continue
+    }
+    number, err := strconv.Atoi(fields[2])
+    if err != nil {
+      return nil, fmt.Errorf("malformed port in masscan list line %q: %v", scanner.Text(), err)
+    }
+    add(fields[3], &storage.Port{
+      Number: number,
+      Protocol: fields[1],
+    })
+  }
+  return hosts, scanner.Err()
+}
+
+// ImportStats summarises an import.
+type ImportStats struct {
+  Hosts int
+  Unmapped int
+  // Hostnames dropped for not matching any target.
+  OutOfScope int
+  NewSubdomains int
+  NewPorts int
+}
+
+// Importer writes historical scan results into the db.
+type Importer struct {
+  db *storage.Client
+  resolver *resolver.Client
+  // Reports whether a hostname belongs to a target.
+  inScope func(string) bool
+  // Addresses of stored subdomains, resolved on first use.
+  byIP map[string][]*storage.Subdomain
+}
+
+// NewImporter returns a new importer. Hostnames inScope rejects are dropped
+// rather than stored.
+func NewImporter(db *storage.Client, resolver *resolver.Client, inScope func(string) bool) *Importer {
+  return &Importer{
+    db: db,
+    resolver: resolver,
+    inScope: inScope,
+  }
+}
+
+// Import maps each host to stored subdomains by hostname, creating them if
+// needed, or by resolving stored subdomains to find those sharing the host's
+// IP. Ports are then stored the same way scans store them, tagged with profile.
+func (i *Importer) Import(ctx context.Context, hosts []*Host, profile string) (*ImportStats, error) {
+  stats := &ImportStats{}
+  for _, host := range hosts {
+    stats.Hosts++
+    subdomains := []*storage.Subdomain{}
+    for _, hostname := range host.Hostnames {
+      if !i.inScope(hostname) {
+        log.Printf("Hostname %q of host %s is out of scope, skipping", hostname, host.IP)
+        stats.OutOfScope++
+        continue
+      }
+      subdomain, created, err := i.subdomain(hostname)
+      if err != nil {
+        return nil, err
+      }
+      if subdomain == nil {
+        continue
+      }
+      if created {
+        stats.NewSubdomains++
+      }
+      subdomains = append(subdomains, subdomain)
+    }
+    if len(subdomains) == 0 && host.IP != "" {
+      if err := i.resolveStored(ctx); err != nil {
+        return nil, err
+      }
+      subdomains = i.byIP[host.IP]
+    }
+    if len(subdomains) == 0 {
+      log.Printf("No subdomain found for host %s, skipping", host.IP)
+      stats.Unmapped++
+      continue
+    }
+    for _, subdomain := range subdomains {
+      if err := i.db.RecordSource(subdomain, storage.SourceImport); err != nil {
+        return nil, err
+      }
+      ports := fanOut(host.Ports, subdomain.Name)
+      for _, port := range ports {
+        port.Profile = profile
+      }
+      inserted, err := StorePorts(i.db, ports)
+      if err != nil {
+        return nil, err
+      }
+      stats.NewPorts += len(inserted)
+    }
+  }
+  return stats, nil
+}
+
+// subdomain returns the stored subdomain for hostname, creating it and its
+// domain if it doesn't exist yet. It returns nil for names that aren't under a
+// public suffix.
+func (i *Importer) subdomain(hostname string) (*storage.Subdomain, bool, error) {
+  domainName, err := publicsuffix.EffectiveTLDPlusOne(hostname)
+  if err != nil {
+    return nil, false, nil
+  }
+  domain := &storage.Domain{Name: domainName}
+  if _, err := i.db.InsertDomain(domain); err != nil {
+    return nil, false, err
+  }
+  subdomain := &storage.Subdomain{
+    Name: hostname,
+    Domain: domainName,
+    Source: storage.SourceImport,
+  }
+  exists, err := i.db.SubdomainExists(subdomain)
+  if err != nil {
+    return nil, false, err
+  }
+  if exists {
+    return subdomain, false, nil
+  }
+  if err := i.db.InsertSubdomain(subdomain); err != nil {
+    return nil, false, err
+  }
+  return subdomain, true, nil
+}
+
+// resolveStored resolves every stored subdomain once to map IPs back to them.
+func (i *Importer) resolveStored(ctx context.Context) error {
+  if i.byIP != nil {
+    return nil
+  }
+  i.byIP = map[string][]*storage.Subdomain{}
+  subdomains, err := i.db.FindSubdomains(&storage.SubdomainFilter{})
+  if err != nil {
+    return err
+  }
+  log.Printf("Resolving %d stored subdomains to map IPs", len(subdomains))
+  for _, subdomain := range subdomains {
+    ips, err := i.resolver.LookupIP(ctx, subdomain.Name)
+    if err != nil {
+      continue
+    }
+    for _, ip := range ips {
+      i.byIP[ip.String()] = append(i.byIP[ip.String()], subdomain)
+    }
+  }
+  return nil
+}
+
+// StorePorts inserts the ports that haven't been seen before and returns them.
+func StorePorts(db *storage.Client, ports []*storage.Port) ([]*storage.Port, error) {
+  inserted := []*storage.Port{}
+  for _, port := range ports {
+    exists, err := db.PortExists(port)
+    if err != nil {
+      return nil, fmt.Errorf("failed to check if port %v exists: %v", port, err)
+    }
+    if exists {
+      continue
+    }
+    if err := db.InsertPort(port); err != nil {
+      return nil, fmt.Errorf("failed to insert port %v: %v", port, err)
+    }
+    inserted = append(inserted, port)
+  }
+  return inserted, nil
+}
@@ -0,0 +1,126 @@
+package portscan
+
+import (
+  "fmt"
+  "io/ioutil"
+  "reflect"
+  "testing"
+
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+func TestParseNmapXML(t *testing.T) {
+  data, err := ioutil.ReadFile("testdata/nmap.xml")
+  if err != nil {
+    t.Fatalf("failed to read fixture: %v", err)
+  }
+  got, err := ParseNmapXML(data)
+  if err != nil {
+    t.Fatalf("ParseNmapXML() error = %v", err)
+  }
+  want := []*Host{
+    {
+      IP: "192.0.2.10",
+      Hostnames: []string{"app.example.com"},
+      Ports: []*storage.Port{
+        {Number: 22, Protocol: "tcp", Service: "ssh", Product: "OpenSSH", Version: "7.4"},
+        {Number: 443, Protocol: "tcp", Service: "https", Product: "nginx"},
+      },
+    },
+    {
+      IP: "192.0.2.20",
+      Ports: []*storage.Port{
+        {Number: 80, Protocol: "tcp", Service: "http"},
+      },
+    },
+  }
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("ParseNmapXML() = %s, want %s", format(got), format(want))
+  }
+}
+
+func TestParseMasscan(t *testing.T) {
+  tests := []struct {
+    name string
+    data string
+    want []*Host
+    wantErr bool
+  }{
+    {
+      name: "json",
+      data: `[
+{   "ip": "192.0.2.1",   "timestamp": "1600000000", "ports": [ {"port": 443, "proto": "tcp", "status": "open", "reason": "syn-ack", "ttl": 54} ] },
+{   "ip": "192.0.2.1",   "timestamp": "1600000001", "ports": [ {"port": 22, "proto": "tcp", "service": {"name": "ssh", "banner": "SSH-2.0-OpenSSH_7.4"} } ] },
+{   "ip": "192.0.2.2",   "timestamp": "1600000002", "ports": [ {"port": 8080, "proto": "tcp", "status": "closed"} ] },
+]`,
+      want: []*Host{
+        {
+          IP: "192.0.2.1",
+          Ports: []*storage.Port{
+            {Number: 443, Protocol: "tcp", Service: "https"},
+            {Number: 22, Protocol: "tcp", Service: "ssh"},
+          },
+        },
+      },
+    },
+    {
+      name: "list",
+      data: `#masscan
+open tcp 80 192.0.2.1 1600000000
+open tcp 12345 192.0.2.1 1600000000
+closed tcp 443 192.0.2.1 1600000000
+open udp 161 192.0.2.3 1600000000
+# end
+`,
+      want: []*Host{
+        {
+          IP: "192.0.2.1",
+          Ports: []*storage.Port{
+            {Number: 80, Protocol: "tcp", Service: "http"},
+            {Number: 12345, Protocol: "tcp"},
+          },
+        },
+        {
+          IP: "192.0.2.3",
+          Ports: []*storage.Port{
+            {Number: 161, Protocol: "udp"},
+          },
+        },
+      },
+    },
+    {
+      name: "malformed list line",
+      data: "open tcp 80\n",
+      wantErr: true,
+    },
+    {
+      name: "malformed port",
+      data: "open tcp http 192.0.2.1 1600000000\n",
+      wantErr: true,
+    },
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      got, err := ParseMasscan([]byte(tt.data))
+      if (err != nil) != tt.wantErr {
+        t.Fatalf("ParseMasscan() error = %v, wantErr %v", err, tt.wantErr)
+      }
+      if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+        t.Errorf("ParseMasscan() = %s, want %s", format(got), format(tt.want))
+      }
+    })
+  }
+}
+
+// format prints hosts with their ports rather than pointers.
+func format(hosts []*Host) string {
+  out := "["
+  for _, host := range hosts {
+    out += host.IP + " " + fmt.Sprint(host.Hostnames) + " {"
+    for _, port := range host.Ports {
+      out += fmt.Sprintf(" %+v", *port)
+    }
+    out += " } "
+  }
+  return out + "]"
+}
@@ -1,38 +1,183 @@
-// Package portscan uses nmap to port scan targets.
+// Package portscan uses nmap or a built-in TCP connect scanner to port scan
+// targets.
 package portscan
 
 import (
   "context"
+  "fmt"
   "log"
+  "strconv"
+  "time"
 
   "github.com/Ullaakut/nmap"
   "github.com/dlegs/bounty-hunter/notify"
+  "github.com/dlegs/bounty-hunter/resolver"
   "github.com/dlegs/bounty-hunter/storage"
 )
 
-// Client holds db and slack dependencies.
+// Scanners that can be selected in Options.
+const (
+  ScannerNmap = "nmap"
+  ScannerConnect = "connect"
+)
+
+// Options configures how hosts are scanned.
+type Options struct {
+  // Scanner is either ScannerNmap or ScannerConnect.
+  Scanner string
+  // Ports scanned by the connect scanner.
+  Ports []int
+  // Timeout of a single connection attempt.
+  Timeout time.Duration
+  // Number of concurrent connection attempts per host.
+  Workers int
+  // Maximum number of connection attempts per second per host, unlimited if 0.
+  Rate int
+  // Run nmap service detection on ports found open by the connect scanner.
+  ServiceDetection bool
+  // Profiles selects the nmap options used per scan.
+  Profiles *Profiles
+  // How long scan results of an IP are reused for other hostnames resolving
+  // to it. Every hostname is scanned separately if 0.
+  CacheTTL time.Duration
+  // CDNPolicy is one of CDNScan, CDNWeb or CDNSkip.
+  CDNPolicy string
+  // Also scan UDP ports, with nmap -sU or the native prober when the connect
+  // scanner is used.
+  UDP bool
+  // UDP ports to scan, DefaultUDPPorts if empty.
+  UDPPorts []int
+}
+
+// Client holds db, slack and resolver dependencies.
 type Client struct {
   db *storage.Client
   slack *notify.Client
+  resolver *resolver.Client
+  opts *Options
+  cache *ipCache
 }
 
 // New returns a new client.
-func New(db *storage.Client, slack *notify.Client) *Client {
+func New(db *storage.Client, slack *notify.Client, resolver *resolver.Client, opts *Options) *Client {
   return &Client{
     db: db,
     slack: slack,
+    resolver: resolver,
+    opts: opts,
+    cache: newIPCache(opts.CacheTTL),
   }
 }
 
-// Scan performs an nmap scan and sends found ports to the portsc channel.
+// Scan port scans the subdomain with the configured scanner and sends found
+// ports to the portsc channel.
 func (c *Client) Scan(ctx context.Context, subdomain *storage.Subdomain, rescan bool, portsc chan []*storage.Port) {
-  scanner, err := nmap.NewScanner(
-    nmap.WithTargets(subdomain.Name),
-    nmap.WithTimingTemplate(4),
-    nmap.WithServiceInfo(),
+  profile := c.opts.Profiles.For(subdomain.Domain)
+  if c.opts.Scanner == ScannerConnect && profile.Ports == "" {
+    // The connect scanner falls back to the configured ports, so label the
+    // results with what was actually scanned.
+    profile = &Profile{
+      Name: ScannerConnect,
+      Timing: profile.Timing,
+      ServiceInfo: profile.ServiceInfo,
+    }
+  }
+  // Key the scan on the first address the subdomain resolves to.
+  ip := ""
+  if ips, err := c.resolver.LookupIP(ctx, subdomain.Name); err == nil && len(ips) > 0 {
+    ip = ips[0].String()
+    if subdomain.CDN = CDN(ips[0]); subdomain.CDN != "" {
+      log.Printf("Host %q is behind %s [%s]", subdomain.Name, subdomain.CDN, ip)
+      if err := c.db.UpdateCDN(subdomain); err != nil {
+        log.Fatalf("failed to update cdn of subdomain %v: %v", subdomain, err)
+      }
+      switch c.opts.CDNPolicy {
+      case CDNSkip:
+        portsc <- []*storage.Port{}
+        return
+      case CDNWeb:
+        profile = &Profile{
+          Name: profile.Name + "+cdn-web",
+          Ports: cdnWebPorts,
+          Timing: profile.Timing,
+          ServiceInfo: profile.ServiceInfo,
+        }
+      }
+    }
+  }
+
+  ports := c.cache.scan(ip, profile.Name, subdomain.Name, func() []*storage.Port {
+    ports := c.tcpScan(ctx, subdomain.Name, profile)
+    if c.opts.UDP && subdomain.CDN == "" {
+      ports = append(ports, c.udpScan(ctx, subdomain.Name, profile)...)
+    }
+    return ports
+  })
+
+  for _, port := range ports {
+    port.Profile = profile.Name
+  }
+  // Ports that are new get inserted into the DB.
+  inserted, err := StorePorts(c.db, ports)
+  if err != nil {
+    log.Fatal(err)
+  }
+  for _, port := range inserted {
+    if port.Protocol == "udp" && unusualUDPServices[port.Service] {
+      finding := &storage.Finding{
+        Target: port.Subdomain,
+        Kind: "udp-exposure",
+        Severity: storage.SeverityMedium,
+        Detail: fmt.Sprintf("Exposed UDP service %s on port %d/udp", port.Service, port.Number),
+      }
+      if err := c.db.InsertFinding(finding); err != nil {
+        log.Fatalf("failed to insert finding %v: %v", finding, err)
+      }
+      if err := c.slack.NotifyFinding(finding); err != nil {
+        log.Fatalf("failed to notify finding %v: %v", finding, err)
+      }
+    }
+    // TODO: make sure this isn't sent redundantly.
+    // If we've seen the host already, alert that a new port opened up.
+    /*
+    if rescan {
+      if err = c.slack.NotifyPort(port); err != nil {
+        log.Fatalf("failed to notify new port %v: %v", port, err)
+      }
+    }*/
+    // Otherwise, do nothing since we'll send an alert for the whole host
+    // later.
+  }
+  portsc <- ports
+}
+
+// tcpScan scans the TCP ports of the host with the configured scanner.
+func (c *Client) tcpScan(ctx context.Context, host string, profile *Profile) []*storage.Port {
+  switch c.opts.Scanner {
+  case ScannerConnect:
+    ports := c.connectScan(ctx, host, profile)
+    if !c.opts.ServiceDetection || len(ports) == 0 {
+      return ports
+    }
+    numbers := []string{}
+    for _, port := range ports {
+      numbers = append(numbers, strconv.Itoa(port.Number))
+    }
+    return c.nmapScan(ctx, host, nmap.WithTimingTemplate(nmap.Timing(profile.Timing)), nmap.WithServiceInfo(), nmap.WithPorts(numbers...))
+  default:
+    return c.nmapScan(ctx, host, profile.nmapOptions()...)
+  }
+}
+
+// nmapScan performs an nmap scan of the host with the given options and returns
+// its open ports.
+func (c *Client) nmapScan(ctx context.Context, host string, options ...func(*nmap.Scanner)) []*storage.Port {
+  options = append([]func(*nmap.Scanner){
+    nmap.WithTargets(host),
     nmap.WithSkipHostDiscovery(),
     nmap.WithContext(ctx),
-  )
+  }, options...)
+  scanner, err := nmap.NewScanner(options...)
   if err != nil {
     log.Fatalf("failed to create nmap scanner: %v", err)
   }
@@ -53,36 +198,15 @@ func (c *Client) Scan(ctx context.Context, subdomain *storage.Subdomain, rescan
       if p.State.State != "open" {
         continue
       }
-      port := &storage.Port{
+      ports = append(ports, &storage.Port{
         Number: int(p.ID),
         Subdomain: host.Hostnames[0].Name,
         Protocol: p.Protocol,
         Service: p.Service.Name,
         Product: p.Service.Product,
         Version: p.Service.Version,
-      }
-      ports = append(ports, port)
-      exists, err := c.db.PortExists(port)
-      if err != nil {
-        log.Fatalf("failed to check if port %v exists: %v", port, err)
-      }
-      // Port is new, so insert into DB.
-      if !exists {
-        if err =c.db.InsertPort(port); err != nil {
-          log.Fatalf("failed to insert port %v: %v", port, err)
-        }
-        // TODO: make sure this isn't sent redundantly.
-        // If we've seen the host already, alert that a new port opened up. 
-        /*
-        if rescan {
-          if err = c.slack.NotifyPort(port); err != nil {
-            log.Fatalf("failed to notify new port %v: %v", port, err)
-          }
-        }*/
-        // Otherwise, do nothing since we'll send an alert for the whole host
-        // later.
-      }
+      })
     }
   }
-  portsc <- ports
+  return ports
 }
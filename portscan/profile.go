@@ -0,0 +1,150 @@
+package portscan
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+
+  "github.com/Ullaakut/nmap"
+)
+
+// DefaultProfile is the name of the profile matching nmap's default top 1000
+// TCP ports with service detection.
+const DefaultProfile = "default"
+
+// Profile is a named set of nmap options.
+type Profile struct {
+  Name string `json:"name"`
+  // Ports to scan e.g. "80,443,8000-8100". Takes precedence over TopPorts.
+  Ports string `json:"ports,omitempty"`
+  // Scan the N most common ports. nmap's default top 1000 if neither Ports nor
+  // TopPorts are set.
+  TopPorts int `json:"top_ports,omitempty"`
+  // Scan UDP instead of TCP, which needs nmap to run as root.
+  UDP bool `json:"udp,omitempty"`
+  // nmap timing template from 0 (slowest) to 5 (fastest).
+  Timing int `json:"timing"`
+  // Probe open ports for service and version info.
+  ServiceInfo bool `json:"service_info"`
+}
+
+// Profiles holds the available scan profiles and which one to use where.
+type Profiles struct {
+  // Profile used unless a program profile applies.
+  Default string `json:"default"`
+  // Profiles used per program, keyed by domain.
+  Programs map[string]string `json:"programs,omitempty"`
+  Profiles []*Profile `json:"profiles"`
+  byName map[string]*Profile
+}
+
+// builtinProfiles are always available and can be overridden by name.
+var builtinProfiles = []*Profile{
+  {Name: DefaultProfile, Timing: 4, ServiceInfo: true},
+  {Name: "quick-web", Ports: "80,443,3000,5000,8000,8008,8080,8081,8088,8443,8888,9000,9090,9443", Timing: 4, ServiceInfo: true},
+  {Name: "full-tcp", Ports: "1-65535", Timing: 4, ServiceInfo: true},
+  {Name: "top-100", TopPorts: 100, Timing: 4, ServiceInfo: true},
+  {Name: "udp-top-100", TopPorts: 100, UDP: true, Timing: 4},
+}
+
+// LoadProfiles reads scan profiles from a JSON file, merged on top of the
+// built-in profiles. If file is empty only the built-in profiles are used.
+func LoadProfiles(file string) (*Profiles, error) {
+  p := &Profiles{}
+  if file != "" {
+    config, err := ioutil.ReadFile(file)
+    if err != nil {
+      return nil, fmt.Errorf("failed to read scan profiles file: %v", err)
+    }
+    if err := json.Unmarshal(config, p); err != nil {
+      return nil, fmt.Errorf("failed to parse scan profiles json: %v", err)
+    }
+  }
+  if p.Default == "" {
+    p.Default = DefaultProfile
+  }
+  p.byName = map[string]*Profile{}
+  for _, profile := range append(builtinProfiles, p.Profiles...) {
+    if profile.Name == "" {
+      return nil, fmt.Errorf("scan profile is missing a name")
+    }
+    if profile.Timing < 0 || profile.Timing > 5 {
+      return nil, fmt.Errorf("scan profile %q has invalid timing %d", profile.Name, profile.Timing)
+    }
+    if profile.Ports != "" {
+      if _, err := ParsePorts(profile.Ports); err != nil {
+        return nil, fmt.Errorf("scan profile %q: %v", profile.Name, err)
+      }
+    }
+    p.byName[profile.Name] = profile
+  }
+  for _, name := range append([]string{p.Default}, programProfiles(p.Programs)...) {
+    if name != "" && p.byName[name] == nil {
+      return nil, fmt.Errorf("unknown scan profile %q", name)
+    }
+  }
+  return p, nil
+}
+
+// SetDefault overrides the default profile.
+func (p *Profiles) SetDefault(name string) error {
+  if p.byName[name] == nil {
+    return fmt.Errorf("unknown scan profile %q", name)
+  }
+  p.Default = name
+  return nil
+}
+
+// CheckScanner returns an error if a profile in use needs nmap but the
+// scanner isn't nmap. The connect scanner only scans explicit TCP port lists,
+// so it can't run UDP or top ports profiles.
+func (p *Profiles) CheckScanner(scanner string) error {
+  if scanner == ScannerNmap {
+    return nil
+  }
+  for _, name := range append([]string{p.Default}, programProfiles(p.Programs)...) {
+    profile := p.byName[name]
+    if profile == nil {
+      continue
+    }
+    if profile.UDP || (profile.Ports == "" && profile.TopPorts > 0) {
+      return fmt.Errorf("scan profile %q needs the nmap scanner, the %s scanner only scans TCP port lists", name, scanner)
+    }
+  }
+  return nil
+}
+
+// For returns the profile to scan a subdomain of domain with.
+func (p *Profiles) For(domain string) *Profile {
+  if name, ok := p.Programs[domain]; ok {
+    return p.byName[name]
+  }
+  return p.byName[p.Default]
+}
+
+// nmapOptions returns the nmap options of the profile.
+func (p *Profile) nmapOptions() []func(*nmap.Scanner) {
+  options := []func(*nmap.Scanner){
+    nmap.WithTimingTemplate(nmap.Timing(p.Timing)),
+  }
+  if p.ServiceInfo {
+    options = append(options, nmap.WithServiceInfo())
+  }
+  if p.UDP {
+    options = append(options, nmap.WithUDPScan())
+  }
+  if p.Ports != "" {
+    options = append(options, nmap.WithPorts(p.Ports))
+  } else if p.TopPorts > 0 {
+    options = append(options, nmap.WithMostCommonPorts(p.TopPorts))
+  }
+  return options
+}
+
+func programProfiles(programs map[string]string) []string {
+  names := []string{}
+  for _, name := range programs {
+    names = append(names, name)
+  }
+  return names
+}
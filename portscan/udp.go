@@ -0,0 +1,152 @@
+package portscan
+
+import (
+  "context"
+  "encoding/binary"
+  "log"
+  "net"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/Ullaakut/nmap"
+  "github.com/miekg/dns"
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// DefaultUDPPorts are the UDP ports scanned when no UDP port list is given.
+var DefaultUDPPorts = []int{53, 123, 161, 500, 1900, 11211}
+
+// udpProbe is a payload a UDP service is expected to answer.
+type udpProbe struct {
+  service string
+  payload []byte
+}
+
+// udpProbes are sent by the native UDP scanner, keyed by port. UDP services
+// stay silent on unexpected input so a port without a probe can't be detected.
+var udpProbes = map[int]udpProbe{
+  53: {"domain", dnsProbe()},
+  123: {"ntp", ntpProbe()},
+  161: {"snmp", snmpProbe},
+  500: {"isakmp", ikeProbe()},
+  1900: {"upnp", []byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n")},
+  11211: {"memcache", []byte("\x00\x00\x00\x00\x00\x01\x00\x00stats\r\n")},
+}
+
+// unusualUDPServices are notified as findings when exposed, since they are
+// commonly abused for amplification or leak internal information.
+var unusualUDPServices = map[string]bool{
+  "snmp": true,
+  "ntp": true,
+  "isakmp": true,
+  "upnp": true,
+  "memcache": true,
+}
+
+// snmpProbe is an SNMPv2c get-request of sysDescr.0 with community "public".
+var snmpProbe = []byte{
+  0x30, 0x29, 0x02, 0x01, 0x01, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c',
+  0xa0, 0x1c, 0x02, 0x04, 0x00, 0x00, 0x00, 0x01, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00,
+  0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x05, 0x00,
+}
+
+// dnsProbe is a query for the root nameservers.
+func dnsProbe() []byte {
+  m := new(dns.Msg)
+  m.SetQuestion(".", dns.TypeNS)
+  b, err := m.Pack()
+  if err != nil {
+    panic(err)
+  }
+  return b
+}
+
+// ntpProbe is an NTPv3 client request.
+func ntpProbe() []byte {
+  b := make([]byte, 48)
+  b[0] = 0x1b
+  return b
+}
+
+// ikeProbe is an IKEv1 main mode proposal of 3DES/SHA1/PSK/MODP1024.
+func ikeProbe() []byte {
+  attributes := [][2]uint16{{0x8001, 5}, {0x8002, 2}, {0x8003, 1}, {0x8004, 2}, {0x800b, 1}, {0x800c, 28800}}
+  transform := []byte{0, 0, 0, 0, 1, 1, 0, 0}
+  for _, a := range attributes {
+    transform = append(transform, byte(a[0]>>8), byte(a[0]), byte(a[1]>>8), byte(a[1]))
+  }
+  binary.BigEndian.PutUint16(transform[2:], uint16(len(transform)))
+  proposal := append([]byte{0, 0, 0, 0, 1, 1, 0, 1}, transform...)
+  binary.BigEndian.PutUint16(proposal[2:], uint16(len(proposal)))
+  sa := append([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1}, proposal...)
+  binary.BigEndian.PutUint16(sa[2:], uint16(len(sa)))
+  header := []byte{
+    0x42, 0x48, 0x55, 0x4e, 0x54, 0x45, 0x52, 0x01, // initiator cookie
+    0, 0, 0, 0, 0, 0, 0, 0, // responder cookie
+    1, 0x10, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+  }
+  packet := append(header, sa...)
+  binary.BigEndian.PutUint32(packet[24:], uint32(len(packet)))
+  return packet
+}
+
+// udpScan scans the configured UDP ports of the host with nmap -sU, or with the
+// native prober when the connect scanner is used.
+func (c *Client) udpScan(ctx context.Context, host string, profile *Profile) []*storage.Port {
+  ports := c.opts.UDPPorts
+  if len(ports) == 0 {
+    ports = DefaultUDPPorts
+  }
+  if c.opts.Scanner != ScannerConnect {
+    numbers := []string{}
+    for _, port := range ports {
+      numbers = append(numbers, strconv.Itoa(port))
+    }
+    return c.nmapScan(ctx, host, nmap.WithUDPScan(), nmap.WithTimingTemplate(nmap.Timing(profile.Timing)), nmap.WithServiceInfo(), nmap.WithPorts(numbers...))
+  }
+
+  open := []*storage.Port{}
+  for _, port := range ports {
+    probe, ok := udpProbes[port]
+    if !ok {
+      log.Printf("no UDP probe for port %d, skipping", port)
+      continue
+    }
+    if c.probeUDP(ctx, host, port, probe.payload) {
+      log.Printf("\tPort %d/udp [open] %s", port, probe.service)
+      open = append(open, &storage.Port{
+        Number: port,
+        Subdomain: host,
+        Protocol: "udp",
+        Service: probe.service,
+      })
+    }
+  }
+  return open
+}
+
+// probeUDP sends the payload to the port twice and reports whether anything
+// answered.
+func (c *Client) probeUDP(ctx context.Context, host string, port int, payload []byte) bool {
+  dialer := net.Dialer{Timeout: c.opts.Timeout}
+  conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(port)))
+  if err != nil {
+    return false
+  }
+  defer conn.Close()
+  buf := make([]byte, 1500)
+  for attempt := 0; attempt < 2; attempt++ {
+    if _, err := conn.Write(payload); err != nil {
+      return false
+    }
+    conn.SetReadDeadline(time.Now().Add(c.opts.Timeout))
+    if n, err := conn.Read(buf); err == nil && n > 0 {
+      return true
+    } else if err != nil && strings.Contains(err.Error(), "refused") {
+      // ICMP port unreachable, the port is closed.
+      return false
+    }
+  }
+  return false
+}
@@ -0,0 +1,173 @@
+// Package registrable checks whether the domains a subdomain's CNAME, NS and
+// MX records point at can be registered by anyone.
+package registrable
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "strings"
+  "sync"
+  "time"
+
+  "golang.org/x/net/publicsuffix"
+  "github.com/dlegs/bounty-hunter/notify"
+  "github.com/dlegs/bounty-hunter/resolver"
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// Client holds db, slack, resolver and availability checker dependencies.
+type Client struct {
+  db *storage.Client
+  slack *notify.Client
+  resolver *resolver.Client
+  checker Checker
+  // How long the availability of a domain is cached for.
+  ttl time.Duration
+  mu sync.Mutex
+  cache map[string]*availability
+}
+
+// availability is a cached answer of the checker.
+type availability struct {
+  available bool
+  checkedAt time.Time
+}
+
+// target is a name a record of the subdomain points at.
+type target struct {
+  record string
+  name string
+}
+
+// New returns a new registrable domain client.
+func New(db *storage.Client, slack *notify.Client, resolver *resolver.Client, checker Checker, ttl time.Duration) *Client {
+  return &Client{
+    db: db,
+    slack: slack,
+    resolver: resolver,
+    checker: checker,
+    ttl: ttl,
+    cache: map[string]*availability{},
+  }
+}
+
+// Identify checks the subdomain for records pointing at available domains,
+// stores each as a takeover and notifies it. Takeovers of domains that have
+// since been registered are marked fixed.
+func (c *Client) Identify(ctx context.Context, subdomain *storage.Subdomain) []*storage.Takeover {
+  takeovers, err := c.Check(ctx, subdomain)
+  if err != nil {
+    log.Printf("failed to check %q for registrable domains: %v", subdomain.Name, err)
+    return nil
+  }
+  if len(takeovers) == 0 {
+    if err := c.db.FixTakeovers(subdomain, storage.TakeoverDomain); err != nil {
+      log.Printf("failed to mark domain takeovers of %q fixed: %v", subdomain.Name, err)
+    }
+    return nil
+  }
+  for _, t := range takeovers {
+    log.Printf("Possible takeover of %q by registering %s: %s", subdomain.Name, t.Service, strings.Join(t.Records, ", "))
+    if err := c.db.UpsertTakeover(t); err != nil {
+      log.Printf("failed to store takeover of %q: %v", subdomain.Name, err)
+    }
+    if err := c.slack.NotifyTakeover(&storage.Subdomain{Name: subdomain.Name, Takeover: t.Service, TakeoverEvidence: t}); err != nil {
+      log.Printf("failed to notify takeover of %q: %v", subdomain.Name, err)
+    }
+  }
+  return takeovers
+}
+
+// Check returns a takeover for each available domain the CNAME chain, NS
+// delegation or MX records of the subdomain point at.
+func (c *Client) Check(ctx context.Context, subdomain *storage.Subdomain) ([]*storage.Takeover, error) {
+  targets, err := c.targets(ctx, subdomain.Name)
+  if err != nil {
+    return nil, err
+  }
+  byDomain := map[string]*storage.Takeover{}
+  takeovers := []*storage.Takeover{}
+  for _, t := range targets {
+    domain, err := publicsuffix.EffectiveTLDPlusOne(t.name)
+    if err != nil || domain == subdomain.Domain {
+      continue
+    }
+    available, err := c.available(ctx, domain)
+    if err != nil {
+      log.Printf("failed to check availability of %q: %v", domain, err)
+      continue
+    }
+    if !available {
+      continue
+    }
+    record := fmt.Sprintf("%s %s: %s is available", t.record, t.name, domain)
+    if takeover, ok := byDomain[domain]; ok {
+      takeover.Records = append(takeover.Records, record)
+      continue
+    }
+    takeover := &storage.Takeover{
+      Subdomain: subdomain.Name,
+      Kind: storage.TakeoverDomain,
+      Service: domain,
+      Confidence: storage.ConfidenceRegistrable,
+      Records: []string{record},
+    }
+    byDomain[domain] = takeover
+    takeovers = append(takeovers, takeover)
+  }
+  return takeovers, nil
+}
+
+// targets returns the CNAME, NS and MX targets of the name.
+func (c *Client) targets(ctx context.Context, name string) ([]*target, error) {
+  targets := []*target{}
+  chain, err := c.resolver.CNAMEChain(ctx, name)
+  if err != nil {
+    return nil, err
+  }
+  for _, cname := range chain {
+    targets = append(targets, &target{record: "CNAME", name: cname})
+  }
+  nameservers, err := c.resolver.Delegation(ctx, name)
+  if err != nil {
+    log.Printf("failed to look up delegation of %q: %v", name, err)
+  }
+  for _, ns := range nameservers {
+    targets = append(targets, &target{record: "NS", name: ns})
+  }
+  exchangers, err := c.resolver.LookupMX(ctx, name)
+  if err != nil {
+    log.Printf("failed to look up mail exchangers of %q: %v", name, err)
+  }
+  for _, mx := range exchangers {
+    targets = append(targets, &target{record: "MX", name: mx})
+  }
+  return targets, nil
+}
+
+// available returns whether the domain can be registered. Domains with
+// nameservers are registered, so only the rest are checked, and answers are
+// cached since many subdomains point at the same domains.
+func (c *Client) available(ctx context.Context, domain string) (bool, error) {
+  c.mu.Lock()
+  cached, ok := c.cache[domain]
+  c.mu.Unlock()
+  if ok && time.Since(cached.checkedAt) < c.ttl {
+    return cached.available, nil
+  }
+
+  available := false
+  if nameservers, err := c.resolver.LookupNS(ctx, domain); err != nil || len(nameservers) == 0 {
+    if available, err = c.checker.Available(ctx, domain); err != nil {
+      return false, err
+    }
+  }
+  c.mu.Lock()
+  c.cache[domain] = &availability{
+    available: available,
+    checkedAt: time.Now(),
+  }
+  c.mu.Unlock()
+  return available, nil
+}
@@ -0,0 +1,156 @@
+package registrable
+
+import (
+  "context"
+  "net"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/miekg/dns"
+  "github.com/dlegs/bounty-hunter/resolver"
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// records served by the test nameserver.
+var records = []string{
+  "expired.example.com. 300 IN CNAME shop.expired-shop.com.",
+  "registered.example.com. 300 IN CNAME app.registered.com.",
+  "registered.com. 300 IN NS ns1.registered.com.",
+  "internal.example.com. 300 IN CNAME other.example.com.",
+  "mail.example.com. 300 IN MX 10 mx1.gone-mail.net.",
+  "mail.example.com. 300 IN MX 20 mx2.gone-mail.net.",
+  "chain.example.com. 300 IN CNAME hop.example.com.",
+  "hop.example.com. 300 IN CNAME cdn.expired-shop.com.",
+}
+
+// serve starts a nameserver on a local port answering from records, and
+// returns its address.
+func serve(t *testing.T) string {
+  answers := map[string][]dns.RR{}
+  for _, record := range records {
+    rr, err := dns.NewRR(record)
+    if err != nil {
+      t.Fatalf("failed to parse record %q: %v", record, err)
+    }
+    key := strings.ToLower(rr.Header().Name) + dns.TypeToString[rr.Header().Rrtype]
+    answers[key] = append(answers[key], rr)
+  }
+  soa, err := dns.NewRR("example.com. 300 IN SOA ns1.example.com. admin.example.com. 1 7200 3600 1209600 300")
+  if err != nil {
+    t.Fatalf("failed to parse soa: %v", err)
+  }
+  handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+    m := new(dns.Msg)
+    m.SetReply(req)
+    q := req.Question[0]
+    m.Answer = answers[strings.ToLower(q.Name)+dns.TypeToString[q.Qtype]]
+    if len(m.Answer) == 0 {
+      m.Ns = []dns.RR{soa}
+    }
+    w.WriteMsg(m)
+  })
+  conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatalf("failed to listen: %v", err)
+  }
+  started := make(chan struct{})
+  server := &dns.Server{PacketConn: conn, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+  go server.ActivateAndServe()
+  <-started
+  t.Cleanup(func() { server.Shutdown() })
+  return conn.LocalAddr().String()
+}
+
+func TestCheck(t *testing.T) {
+  r := resolver.New(serve(t), time.Second)
+  tests := []struct {
+    name string
+    subdomain string
+    available []string
+    want []*storage.Takeover
+    // Domains the checker must have been asked about.
+    wantQueries []string
+  }{
+    {
+      name: "cname to available domain",
+      subdomain: "expired.example.com",
+      available: []string{"expired-shop.com"},
+      want: []*storage.Takeover{{
+        Subdomain: "expired.example.com",
+        Kind: storage.TakeoverDomain,
+        Service: "expired-shop.com",
+        Confidence: storage.ConfidenceRegistrable,
+        Records: []string{"CNAME shop.expired-shop.com: expired-shop.com is available"},
+      }},
+      wantQueries: []string{"expired-shop.com"},
+    },
+    {
+      name: "cname chain to available domain",
+      subdomain: "chain.example.com",
+      available: []string{"expired-shop.com"},
+      want: []*storage.Takeover{{
+        Subdomain: "chain.example.com",
+        Kind: storage.TakeoverDomain,
+        Service: "expired-shop.com",
+        Confidence: storage.ConfidenceRegistrable,
+        Records: []string{"CNAME cdn.expired-shop.com: expired-shop.com is available"},
+      }},
+      wantQueries: []string{"expired-shop.com"},
+    },
+    {
+      name: "cname to unavailable domain",
+      subdomain: "expired.example.com",
+      wantQueries: []string{"expired-shop.com"},
+    },
+    {
+      name: "domains with nameservers are registered",
+      subdomain: "registered.example.com",
+      available: []string{"registered.com"},
+    },
+    {
+      name: "own domain skipped",
+      subdomain: "internal.example.com",
+      available: []string{"example.com"},
+    },
+    {
+      name: "mail exchangers grouped by domain",
+      subdomain: "mail.example.com",
+      available: []string{"gone-mail.net"},
+      want: []*storage.Takeover{{
+        Subdomain: "mail.example.com",
+        Kind: storage.TakeoverDomain,
+        Service: "gone-mail.net",
+        Confidence: storage.ConfidenceRegistrable,
+        Records: []string{
+          "MX mx1.gone-mail.net: gone-mail.net is available",
+          "MX mx2.gone-mail.net: gone-mail.net is available",
+        },
+      }},
+      // The second exchanger is answered from the cache.
+      wantQueries: []string{"gone-mail.net"},
+    },
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      fake := NewFake(tt.available...)
+      c := New(nil, nil, r, fake, time.Hour)
+      got, err := c.Check(context.Background(), &storage.Subdomain{Name: tt.subdomain, Domain: "example.com"})
+      if err != nil {
+        t.Fatalf("Check() error = %v", err)
+      }
+      if len(got) != len(tt.want) {
+        t.Fatalf("Check() returned %d takeovers, want %d", len(got), len(tt.want))
+      }
+      for i := range got {
+        if !reflect.DeepEqual(got[i], tt.want[i]) {
+          t.Errorf("Check() takeover %d = %+v, want %+v", i, got[i], tt.want[i])
+        }
+      }
+      if len(fake.Queries) != len(tt.wantQueries) || (len(tt.wantQueries) > 0 && !reflect.DeepEqual(fake.Queries, tt.wantQueries)) {
+        t.Errorf("checker queried %q, want %q", fake.Queries, tt.wantQueries)
+      }
+    })
+  }
+}
@@ -0,0 +1,56 @@
+package registrable
+
+import (
+  "context"
+  "fmt"
+  "strings"
+  "sync"
+
+  "github.com/haccer/available"
+)
+
+// Checker reports whether a registrable domain is available for anyone to
+// register.
+type Checker interface {
+  Available(ctx context.Context, domain string) (bool, error)
+}
+
+// Whois checks availability by matching whois responses against the known
+// "not found" responses of each TLD.
+type Whois struct{}
+
+// Available queries whois for the domain. It returns an error for TLDs whose
+// whois servers don't give reliable answers.
+func (Whois) Available(ctx context.Context, domain string) (bool, error) {
+  ok, badTLD := available.SafeDomain(domain)
+  if badTLD {
+    return false, fmt.Errorf("no reliable whois server for the tld of %q", domain)
+  }
+  return ok, nil
+}
+
+// Fake answers from a fixed set of available domains, for tests and dry runs
+// without whois access.
+type Fake struct {
+  mu sync.Mutex
+  available map[string]bool
+  // Domains queried in order.
+  Queries []string
+}
+
+// NewFake returns a fake checker reporting the domains as available.
+func NewFake(domains ...string) *Fake {
+  f := &Fake{available: map[string]bool{}}
+  for _, domain := range domains {
+    f.available[strings.ToLower(domain)] = true
+  }
+  return f
+}
+
+// Available returns whether the domain is in the fake's set.
+func (f *Fake) Available(ctx context.Context, domain string) (bool, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  f.Queries = append(f.Queries, domain)
+  return f.available[strings.ToLower(domain)], nil
+}
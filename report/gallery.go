@@ -0,0 +1,229 @@
+// Package report renders stored results into reports for humans.
+package report
+
+import (
+  "context"
+  "encoding/base64"
+  "fmt"
+  "html/template"
+  "io"
+  "log"
+  "sort"
+  "strings"
+  "time"
+
+  "github.com/dlegs/bounty-hunter/screenshot"
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// Ways of grouping the gallery.
+const (
+  GroupDomain = "domain"
+  GroupCluster = "cluster"
+  GroupProgram = "program"
+)
+
+// GalleryOptions select what goes into the gallery and how it is grouped.
+type GalleryOptions struct {
+  // One of the group constants.
+  Group string
+  // Only include subdomains of this domain, if set.
+  Domain string
+  // Bug bounty program names keyed by domain, used when grouping by program.
+  Programs map[string]string
+}
+
+// card is a screenshot in the gallery.
+type card struct {
+  Host string
+  Port int
+  URL string
+  Title string
+  // Status code of the HTTP probe, 0 if the port wasn't probed.
+  StatusCode int
+  // Technologies detected, with versions.
+  Tech []string
+  FirstSeen time.Time
+  TakenAt time.Time
+  // Image as a data URI, so the page needs no other files.
+  Image template.URL
+  // Lowercased text the client-side filter matches against.
+  Search string
+}
+
+// group is a titled section of the gallery.
+type group struct {
+  Name string
+  Cards []*card
+}
+
+// Gallery writes a self-contained HTML page of the latest screenshot of every
+// URL to w, with the images embedded.
+func Gallery(ctx context.Context, db *storage.Client, store screenshot.Store, w io.Writer, opts *GalleryOptions) error {
+  if opts.Group != GroupDomain && opts.Group != GroupCluster && opts.Group != GroupProgram {
+    return fmt.Errorf("unknown gallery grouping %q", opts.Group)
+  }
+  subdomains, err := db.FindSubdomains(&storage.SubdomainFilter{Domain: opts.Domain})
+  if err != nil {
+    return err
+  }
+  byName := map[string]*storage.Subdomain{}
+  for _, subdomain := range subdomains {
+    byName[subdomain.Name] = subdomain
+  }
+  clusters, err := db.Clusters()
+  if err != nil {
+    return err
+  }
+  clusterNames := map[int64]string{}
+  for _, cluster := range clusters {
+    title := cluster.Title
+    if title == "" {
+      title = "Untitled"
+    }
+    clusterNames[cluster.ID] = fmt.Sprintf("%s (cluster %d, %d hosts)", title, cluster.ID, cluster.Size)
+  }
+
+  screenshots, err := db.LatestScreenshots()
+  if err != nil {
+    return err
+  }
+  groups := map[string]*group{}
+  count := 0
+  for _, s := range screenshots {
+    subdomain, ok := byName[s.Subdomain]
+    if !ok {
+      continue
+    }
+    c := &card{
+      Host: s.Subdomain,
+      Port: s.Port,
+      URL: s.URL,
+      Title: s.Title,
+      TakenAt: s.TakenAt,
+    }
+    if len(subdomain.Sources) > 0 {
+      c.FirstSeen = subdomain.Sources[0].FirstSeen
+    }
+    response, err := db.HTTPResponse(s.Subdomain, s.Port)
+    if err != nil {
+      return err
+    }
+    if response != nil {
+      c.StatusCode = response.StatusCode
+    }
+    technologies, err := db.Technologies(s.Subdomain, s.Port)
+    if err != nil {
+      return err
+    }
+    for _, t := range technologies {
+      c.Tech = append(c.Tech, strings.TrimSpace(t.Name+" "+t.Version))
+    }
+    image, err := store.Get(ctx, screenshot.Key(s))
+    if err != nil {
+      log.Printf("failed to read screenshot of %s, leaving it out of the gallery: %v", s.URL, err)
+    } else {
+      c.Image = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(image))
+    }
+    c.Search = strings.ToLower(strings.Join(append([]string{c.Host, c.URL, c.Title}, c.Tech...), " "))
+
+    name := subdomain.Domain
+    switch opts.Group {
+    case GroupCluster:
+      name = clusterNames[s.ClusterID]
+      if name == "" {
+        name = "Unclustered"
+      }
+    case GroupProgram:
+      name = opts.Programs[subdomain.Domain]
+      if name == "" {
+        name = "Unknown program"
+      }
+    }
+    g, ok := groups[name]
+    if !ok {
+      g = &group{Name: name}
+      groups[name] = g
+    }
+    g.Cards = append(g.Cards, c)
+    count++
+  }
+
+  sorted := []*group{}
+  for _, g := range groups {
+    sorted = append(sorted, g)
+  }
+  // Domains and programs alphabetically, clusters largest first.
+  sort.Slice(sorted, func(i, j int) bool {
+    if opts.Group == GroupCluster && len(sorted[i].Cards) != len(sorted[j].Cards) {
+      return len(sorted[i].Cards) > len(sorted[j].Cards)
+    }
+    return sorted[i].Name < sorted[j].Name
+  })
+  return galleryTemplate.Execute(w, map[string]interface{}{
+    "Generated": time.Now().UTC(),
+    "Groups": sorted,
+    "Count": count,
+  })
+}
+
+var galleryTemplate = template.Must(template.New("gallery").Funcs(template.FuncMap{
+  "date": func(t time.Time) string {
+    if t.IsZero() {
+      return "unknown"
+    }
+    return t.Format(time.RFC3339)
+  },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>bounty-hunter screenshots</title>
+<style>
+body { font-family: sans-serif; margin: 1em; background: #f4f4f4; }
+input { font-size: 1em; padding: .4em; width: 30em; }
+h2 { border-bottom: 1px solid #ccc; }
+.cards { display: grid; grid-template-columns: repeat(auto-fill, minmax(320px, 1fr)); gap: 1em; }
+.card { background: #fff; padding: .5em; box-shadow: 0 1px 3px #aaa; overflow-wrap: anywhere; }
+.card img { width: 100%; border: 1px solid #ddd; }
+.card .missing { height: 180px; background: #ddd; text-align: center; line-height: 180px; }
+.meta { font-size: .85em; color: #555; }
+</style>
+</head>
+<body>
+<h1>Screenshots</h1>
+<p class="meta">{{.Count}} pages, generated {{date .Generated}}</p>
+<input id="filter" type="search" placeholder="Filter by host, URL, title or tech" autofocus>
+{{range .Groups}}
+<section class="group">
+<h2>{{.Name}} <span class="meta">({{len .Cards}})</span></h2>
+<div class="cards">
+{{range .Cards}}
+<div class="card" data-search="{{.Search}}">
+<a href="{{.URL}}" target="_blank" rel="noopener noreferrer">{{if .Image}}<img src="{{.Image}}" loading="lazy" alt="{{.URL}}">{{else}}<div class="missing">image missing</div>{{end}}</a>
+<div><b>{{.Host}}</b>:{{.Port}}{{if .StatusCode}} [{{.StatusCode}}]{{end}}</div>
+<div>{{.Title}}</div>
+{{if .Tech}}<div class="meta">{{range $i, $t := .Tech}}{{if $i}}, {{end}}{{$t}}{{end}}</div>{{end}}
+<div class="meta">First seen {{date .FirstSeen}}, captured {{date .TakenAt}}</div>
+</div>
+{{end}}
+</div>
+</section>
+{{end}}
+<script>
+document.getElementById("filter").addEventListener("input", function(e) {
+  var query = e.target.value.toLowerCase();
+  document.querySelectorAll(".group").forEach(function(group) {
+    var visible = 0;
+    group.querySelectorAll(".card").forEach(function(card) {
+      var match = card.dataset.search.indexOf(query) !== -1;
+      card.style.display = match ? "" : "none";
+      if (match) visible++;
+    });
+    group.style.display = visible ? "" : "none";
+  });
+});
+</script>
+</body>
+</html>
+`))
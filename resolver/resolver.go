@@ -0,0 +1,255 @@
+// Package resolver performs DNS lookups on discovered names.
+package resolver
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+  "fmt"
+  "net"
+  "strings"
+  "time"
+
+  "github.com/miekg/dns"
+)
+
+const (
+  // Maximum number of CNAMEs followed before giving up on a chain.
+  maxCNAMEChain = 10
+  // Number of random labels resolved to detect wildcard records.
+  wildcardProbes = 3
+)
+
+// Client holds the resolver used for lookups.
+type Client struct {
+  resolver *net.Resolver
+  // Server raw queries are sent to.
+  server string
+  timeout time.Duration
+}
+
+// New returns a new resolver client. If server is empty the system resolver is
+// used, otherwise all lookups are sent to server (e.g. "1.1.1.1:53").
+func New(server string, timeout time.Duration) *Client {
+  r := net.DefaultResolver
+  if server != "" {
+    r = &net.Resolver{
+      PreferGo: true,
+      Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+        d := net.Dialer{Timeout: timeout}
+        return d.DialContext(ctx, network, server)
+      },
+    }
+  }
+  if server == "" {
+    server = "8.8.8.8:53"
+    if config, err := dns.ClientConfigFromFile("/etc/resolv.conf"); err == nil && len(config.Servers) > 0 {
+      server = net.JoinHostPort(config.Servers[0], config.Port)
+    }
+  }
+  return &Client{
+    resolver: r,
+    server: server,
+    timeout: timeout,
+  }
+}
+
+// Resolves performs a DNS A lookup on the name.
+func (c *Client) Resolves(ctx context.Context, name string) bool {
+  ips, err := c.LookupIP(ctx, name)
+  if err != nil {
+    return false
+  }
+  return len(ips) > 0
+}
+
+// LookupIP returns the IPv4 addresses of the name.
+func (c *Client) LookupIP(ctx context.Context, name string) ([]net.IP, error) {
+  ctx, cancel := context.WithTimeout(ctx, c.timeout)
+  defer cancel()
+  return c.resolver.LookupIP(ctx, "ip4", name)
+}
+
+// WildcardIPs resolves random labels under the domain and returns the set of
+// addresses any of them resolved to, empty if the domain has no wildcard
+// record.
+func (c *Client) WildcardIPs(ctx context.Context, domain string) (map[string]bool, error) {
+  ips := map[string]bool{}
+  for i := 0; i < wildcardProbes; i++ {
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); err != nil {
+      return nil, fmt.Errorf("failed to generate random label: %v", err)
+    }
+    res, err := c.LookupIP(ctx, fmt.Sprintf("%s.%s", hex.EncodeToString(b), domain))
+    if err != nil {
+      continue
+    }
+    for _, ip := range res {
+      ips[ip.String()] = true
+    }
+  }
+  return ips, nil
+}
+
+// OnlyWildcard reports whether every address is one of the wildcard's, i.e.
+// the name likely resolves only because of the wildcard record.
+func OnlyWildcard(ips []net.IP, wildcard map[string]bool) bool {
+  if len(wildcard) == 0 {
+    return false
+  }
+  for _, ip := range ips {
+    if !wildcard[ip.String()] {
+      return false
+    }
+  }
+  return true
+}
+
+// LookupNS returns the hostnames of the authoritative nameservers of the name.
+func (c *Client) LookupNS(ctx context.Context, name string) ([]string, error) {
+  ctx, cancel := context.WithTimeout(ctx, c.timeout)
+  defer cancel()
+  records, err := c.resolver.LookupNS(ctx, name)
+  if err != nil {
+    return nil, err
+  }
+  hosts := []string{}
+  for _, record := range records {
+    hosts = append(hosts, strings.TrimSuffix(record.Host, "."))
+  }
+  return hosts, nil
+}
+
+// CNAMEChain follows the CNAME records of the name and returns every target in
+// order, or nil if the name has no CNAME.
+func (c *Client) CNAMEChain(ctx context.Context, name string) ([]string, error) {
+  chain := []string{}
+  for i := 0; i < maxCNAMEChain; i++ {
+    res, err := c.query(ctx, name, dns.TypeCNAME)
+    if err != nil {
+      return chain, err
+    }
+    target := ""
+    for _, rr := range res.Answer {
+      if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, dns.Fqdn(name)) {
+        target = strings.ToLower(strings.TrimSuffix(cname.Target, "."))
+      }
+    }
+    if target == "" {
+      return chain, nil
+    }
+    chain = append(chain, target)
+    name = target
+  }
+  return chain, fmt.Errorf("cname chain of %q is longer than %d", name, maxCNAMEChain)
+}
+
+// NXDomain returns whether an A query for the name is answered with NXDOMAIN.
+func (c *Client) NXDomain(ctx context.Context, name string) (bool, error) {
+  res, err := c.query(ctx, name, dns.TypeA)
+  if err != nil {
+    return false, err
+  }
+  return res.Rcode == dns.RcodeNameError, nil
+}
+
+// LookupMX returns the mail exchangers of the name, or nil if it has none.
+func (c *Client) LookupMX(ctx context.Context, name string) ([]string, error) {
+  res, err := c.query(ctx, name, dns.TypeMX)
+  if err != nil {
+    return nil, err
+  }
+  hosts := []string{}
+  for _, rr := range res.Answer {
+    if mx, ok := rr.(*dns.MX); ok && mx.Mx != "." {
+      hosts = append(hosts, strings.ToLower(strings.TrimSuffix(mx.Mx, ".")))
+    }
+  }
+  return hosts, nil
+}
+
+// Delegation returns the nameservers the parent zone delegates the name to, or
+// nil if the name isn't the apex of its own zone. It asks the parent's
+// nameservers directly, since recursive lookups of a broken delegation fail.
+func (c *Client) Delegation(ctx context.Context, name string) ([]string, error) {
+  name = strings.ToLower(strings.TrimSuffix(name, "."))
+  res, err := c.query(ctx, name, dns.TypeSOA)
+  if err != nil {
+    return nil, err
+  }
+  // Resolvers answer SERVFAIL for names delegated to nameservers that don't
+  // serve them, otherwise the SOA tells which zone the name belongs to.
+  if res.Rcode != dns.RcodeServerFailure && zoneOf(res) != dns.Fqdn(name) {
+    return nil, nil
+  }
+  labels := dns.SplitDomainName(name)
+  if len(labels) < 2 {
+    return nil, nil
+  }
+  res, err = c.query(ctx, strings.Join(labels[1:], "."), dns.TypeSOA)
+  if err != nil {
+    return nil, err
+  }
+  parent := zoneOf(res)
+  if parent == "" {
+    return nil, fmt.Errorf("failed to find the parent zone of %q", name)
+  }
+  parentServers, err := c.LookupNS(ctx, parent)
+  if err != nil {
+    return nil, fmt.Errorf("failed to look up nameservers of %q: %v", parent, err)
+  }
+  for _, server := range parentServers {
+    ips, err := c.LookupIP(ctx, server)
+    if err != nil || len(ips) == 0 {
+      continue
+    }
+    res, err := c.QueryServer(ctx, name, dns.TypeNS, net.JoinHostPort(ips[0].String(), "53"))
+    if err != nil {
+      continue
+    }
+    hosts := []string{}
+    for _, rr := range append(res.Answer, res.Ns...) {
+      if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, dns.Fqdn(name)) {
+        hosts = append(hosts, strings.ToLower(strings.TrimSuffix(ns.Ns, ".")))
+      }
+    }
+    return hosts, nil
+  }
+  return nil, fmt.Errorf("no nameserver of %q answered for %q", parent, name)
+}
+
+// zoneOf returns the owner of the SOA record in a response, which is the zone
+// the queried name belongs to.
+func zoneOf(res *dns.Msg) string {
+  for _, rr := range append(res.Answer, res.Ns...) {
+    if soa, ok := rr.(*dns.SOA); ok {
+      return strings.ToLower(soa.Hdr.Name)
+    }
+  }
+  return ""
+}
+
+// QueryServer sends a single non-recursive query to server, e.g. to check
+// whether a nameserver is authoritative for the name.
+func (c *Client) QueryServer(ctx context.Context, name string, qtype uint16, server string) (*dns.Msg, error) {
+  return c.exchange(ctx, name, qtype, server, false)
+}
+
+// query sends a single recursive query to the server.
+func (c *Client) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+  return c.exchange(ctx, name, qtype, c.server, true)
+}
+
+func (c *Client) exchange(ctx context.Context, name string, qtype uint16, server string, recursive bool) (*dns.Msg, error) {
+  ctx, cancel := context.WithTimeout(ctx, c.timeout)
+  defer cancel()
+  m := new(dns.Msg)
+  m.SetQuestion(dns.Fqdn(name), qtype)
+  m.RecursionDesired = recursive
+  client := &dns.Client{Timeout: c.timeout}
+  res, _, err := client.ExchangeContext(ctx, m, server)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query %s %q: %v", dns.TypeToString[qtype], name, err)
+  }
+  return res, nil
+}
@@ -0,0 +1,98 @@
+package screenshot
+
+import (
+  "bytes"
+  "fmt"
+  "image"
+  _ "image/jpeg"
+  _ "image/png"
+  "math"
+  "math/bits"
+  "sort"
+  "strconv"
+)
+
+// Size images are scaled down to before the DCT, and size of the block of
+// lowest frequencies kept from it.
+const (
+  phashSize = 32
+  phashBlock = 8
+)
+
+// PHash returns the 64-bit DCT perceptual hash of an image as hex. Images that
+// look alike have hashes a small Hamming distance apart, regardless of
+// scaling or compression.
+func PHash(img []byte) (string, error) {
+  decoded, _, err := image.Decode(bytes.NewReader(img))
+  if err != nil {
+    return "", fmt.Errorf("failed to decode image: %v", err)
+  }
+  pixels := grayscale(decoded, phashSize)
+
+  // 2D DCT-II, only computing the block of lowest frequencies.
+  dct := make([]float64, phashBlock*phashBlock)
+  for u := 0; u < phashBlock; u++ {
+    for v := 0; v < phashBlock; v++ {
+      sum := 0.0
+      for x := 0; x < phashSize; x++ {
+        for y := 0; y < phashSize; y++ {
+          sum += pixels[y*phashSize+x] *
+            math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*phashSize)) *
+            math.Cos(float64(2*y+1)*float64(v)*math.Pi/(2*phashSize))
+        }
+      }
+      dct[v*phashBlock+u] = sum
+    }
+  }
+
+  // The DC term only reflects overall brightness, so leave it out of the
+  // median.
+  sorted := append([]float64{}, dct[1:]...)
+  sort.Float64s(sorted)
+  median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+  var hash uint64
+  for i, value := range dct {
+    if value > median {
+      hash |= 1 << uint(i)
+    }
+  }
+  return fmt.Sprintf("%016x", hash), nil
+}
+
+// Distance returns the Hamming distance between two hashes from PHash.
+func Distance(a, b string) (int, error) {
+  x, err := strconv.ParseUint(a, 16, 64)
+  if err != nil {
+    return 0, fmt.Errorf("malformed perceptual hash %q: %v", a, err)
+  }
+  y, err := strconv.ParseUint(b, 16, 64)
+  if err != nil {
+    return 0, fmt.Errorf("malformed perceptual hash %q: %v", b, err)
+  }
+  return bits.OnesCount64(x ^ y), nil
+}
+
+// grayscale scales the image down to size by size luminance values by
+// averaging the pixels falling into each cell.
+func grayscale(img image.Image, size int) []float64 {
+  bounds := img.Bounds()
+  width, height := bounds.Dx(), bounds.Dy()
+  sums := make([]float64, size*size)
+  counts := make([]float64, size*size)
+  // Sample at most 512 pixels in each direction, plenty to average from.
+  stepX, stepY := width/512+1, height/512+1
+  for y := 0; y < height; y += stepY {
+    for x := 0; x < width; x += stepX {
+      r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+      cell := (y*size/height)*size + x*size/width
+      sums[cell] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+      counts[cell]++
+    }
+  }
+  for i := range sums {
+    if counts[i] > 0 {
+      sums[i] /= counts[i]
+    }
+  }
+  return sums
+}
@@ -0,0 +1,141 @@
+package screenshot
+
+import (
+  "bytes"
+  "image"
+  "image/color"
+  "image/jpeg"
+  "image/png"
+  "math"
+  "testing"
+)
+
+// article draws a layout with a dark header bar and blocks of text.
+func article(x, y float64) uint8 {
+  switch {
+  case y < 0.12:
+    return 40
+  case x > 0.1 && x < 0.6 && math.Mod(y, 0.08) < 0.03:
+    return 90
+  default:
+    return 240
+  }
+}
+
+// login draws a centered form on a gradient.
+func login(x, y float64) uint8 {
+  if x > 0.35 && x < 0.65 && y > 0.3 && y < 0.7 {
+    return 250
+  }
+  return uint8(60 + 120*x)
+}
+
+// render draws a width by height grayscale image from the pattern and encodes
+// it as PNG, or JPEG if lossy.
+func render(t *testing.T, pattern func(x, y float64) uint8, width, height int, lossy bool) []byte {
+  img := image.NewGray(image.Rect(0, 0, width, height))
+  for y := 0; y < height; y++ {
+    for x := 0; x < width; x++ {
+      img.SetGray(x, y, color.Gray{Y: pattern(float64(x)/float64(width), float64(y)/float64(height))})
+    }
+  }
+  var buf bytes.Buffer
+  var err error
+  if lossy {
+    err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 50})
+  } else {
+    err = png.Encode(&buf, img)
+  }
+  if err != nil {
+    t.Fatalf("failed to encode image: %v", err)
+  }
+  return buf.Bytes()
+}
+
+func TestPHash(t *testing.T) {
+  tests := []struct {
+    name string
+    a, b []byte
+    // Inclusive bounds of the distance between the hashes.
+    min, max int
+  }{
+    {
+      name: "identical",
+      a: render(t, article, 1280, 800, false),
+      b: render(t, article, 1280, 800, false),
+      min: 0,
+      max: 0,
+    },
+    {
+      name: "scaled",
+      a: render(t, article, 1280, 800, false),
+      b: render(t, article, 640, 400, false),
+      min: 0,
+      max: 4,
+    },
+    {
+      name: "compressed",
+      a: render(t, article, 1280, 800, false),
+      b: render(t, article, 1280, 800, true),
+      min: 0,
+      max: 4,
+    },
+    {
+      name: "different pages",
+      a: render(t, article, 1280, 800, false),
+      b: render(t, login, 1280, 800, false),
+      min: 16,
+      max: 64,
+    },
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      a, err := PHash(tt.a)
+      if err != nil {
+        t.Fatalf("PHash() error = %v", err)
+      }
+      b, err := PHash(tt.b)
+      if err != nil {
+        t.Fatalf("PHash() error = %v", err)
+      }
+      distance, err := Distance(a, b)
+      if err != nil {
+        t.Fatalf("Distance() error = %v", err)
+      }
+      if distance < tt.min || distance > tt.max {
+        t.Errorf("Distance(%s, %s) = %d, want between %d and %d", a, b, distance, tt.min, tt.max)
+      }
+    })
+  }
+}
+
+func TestPHashInvalidImage(t *testing.T) {
+  if _, err := PHash([]byte("not an image")); err == nil {
+    t.Error("PHash() of garbage succeeded, want error")
+  }
+}
+
+func TestDistance(t *testing.T) {
+  tests := []struct {
+    a, b string
+    want int
+    wantErr bool
+  }{
+    {a: "0000000000000000", b: "0000000000000000", want: 0},
+    {a: "0000000000000000", b: "ffffffffffffffff", want: 64},
+    {a: "8000000000000001", b: "0000000000000001", want: 1},
+    {a: "00ff00ff00ff00ff", b: "ff00ff00ff00ff00", want: 64},
+    {a: "not hex", b: "0000000000000000", wantErr: true},
+    {a: "0000000000000000", b: "10000000000000000", wantErr: true},
+  }
+  for _, tt := range tests {
+    got, err := Distance(tt.a, tt.b)
+    if (err != nil) != tt.wantErr {
+      t.Errorf("Distance(%q, %q) error = %v, wantErr %v", tt.a, tt.b, err, tt.wantErr)
+      continue
+    }
+    if got != tt.want {
+      t.Errorf("Distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+    }
+  }
+}
@@ -0,0 +1,145 @@
+package screenshot
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "time"
+
+  "github.com/chromedp/cdproto/cdp"
+  "github.com/chromedp/cdproto/target"
+  "github.com/chromedp/chromedp"
+)
+
+// Time allowed for the browser to answer a health check after a failed
+// capture.
+const healthTimeout = 5 * time.Second
+
+// job is a page waiting to be captured.
+type job struct {
+  url string
+  done chan *result
+}
+
+// result is the outcome of a job.
+type result struct {
+  buf []byte
+  title string
+  err error
+}
+
+// worker captures queued pages one at a time until the client is closed.
+func (c *Client) worker() {
+  for {
+    select {
+    case <-c.ctx.Done():
+      return
+    case j := <-c.queue:
+      buf, title, err := c.capture(j.url)
+      j.done <- &result{buf: buf, title: title, err: err}
+    }
+  }
+}
+
+// capture loads the url in a new incognito tab of the browser, so a page can't
+// affect the next, and closes the tab when done or after the page timeout. The
+// browser is restarted if it stopped answering. Returns the image and page
+// title.
+func (c *Client) capture(url string) ([]byte, string, error) {
+  browser, err := c.startBrowser()
+  if err != nil {
+    return nil, "", err
+  }
+  tab, cancel, err := newTab(browser)
+  if err != nil {
+    if !alive(browser) {
+      log.Printf("Chrome stopped responding, restarting it")
+      c.restartBrowser(browser)
+    }
+    return nil, "", err
+  }
+  defer cancel()
+  ctx := tab
+  if c.opts.Timeout > 0 {
+    var cancelTimeout context.CancelFunc
+    ctx, cancelTimeout = context.WithTimeout(tab, c.opts.Timeout)
+    defer cancelTimeout()
+  }
+  var buf []byte
+  var title string
+  if err := chromedp.Run(ctx, tasks(url, c.opts, &buf, &title)); err != nil {
+    if !alive(browser) {
+      log.Printf("Chrome stopped responding, restarting it")
+      c.restartBrowser(browser)
+    }
+    return nil, "", fmt.Errorf("failed to run chrome tasks: %v", err)
+  }
+  return buf, title, nil
+}
+
+// newTab opens a tab in a new browser context, which like an incognito window
+// shares no cookies, storage or cache with other tabs. Cancelling the tab
+// disposes of its browser context.
+func newTab(browser context.Context) (context.Context, context.CancelFunc, error) {
+  ctx, cancel := context.WithTimeout(browser, healthTimeout)
+  defer cancel()
+  executor := cdp.WithExecutor(ctx, chromedp.FromContext(browser).Browser)
+  id, err := target.CreateBrowserContext().Do(executor)
+  if err != nil {
+    return nil, nil, fmt.Errorf("failed to create browser context: %v", err)
+  }
+  targetID, err := target.CreateTarget("about:blank").WithBrowserContextID(id).Do(executor)
+  if err != nil {
+    target.DisposeBrowserContext(id).Do(executor)
+    return nil, nil, fmt.Errorf("failed to create tab: %v", err)
+  }
+  tab, cancelTab := chromedp.NewContext(browser, chromedp.WithTargetID(targetID))
+  return tab, func() {
+    cancelTab()
+    // The tab's context is done, so dispose with a new one.
+    ctx, cancel := context.WithTimeout(browser, healthTimeout)
+    defer cancel()
+    target.DisposeBrowserContext(id).Do(cdp.WithExecutor(ctx, chromedp.FromContext(browser).Browser))
+  }, nil
+}
+
+// startBrowser returns the running browser, starting one if needed.
+func (c *Client) startBrowser() (context.Context, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if c.browser != nil {
+    return c.browser, nil
+  }
+  // The first Run starts the browser, and must not have a timeout as that
+  // would stop the browser along with it.
+  browser, cancel := chromedp.NewContext(c.ctx)
+  if err := chromedp.Run(browser); err != nil {
+    cancel()
+    return nil, fmt.Errorf("failed to start chrome: %v", err)
+  }
+  c.browser, c.browserCancel = browser, cancel
+  return browser, nil
+}
+
+// restartBrowser stops the browser if it's still the failed one, so the next
+// capture starts a new one. Other workers may have restarted it already.
+func (c *Client) restartBrowser(failed context.Context) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if c.browser != failed {
+    return
+  }
+  c.browserCancel()
+  c.browser = nil
+}
+
+// alive returns whether the browser still answers.
+func alive(browser context.Context) bool {
+  if browser.Err() != nil {
+    return false
+  }
+  ctx, cancel := context.WithTimeout(browser, healthTimeout)
+  defer cancel()
+  _, err := chromedp.Targets(ctx)
+  return err == nil
+}
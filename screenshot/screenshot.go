@@ -3,66 +3,437 @@ package screenshot
 
 import (
   "context"
+  "crypto/sha256"
+  "encoding/hex"
   "fmt"
-  "io/ioutil"
   "log"
+  "math"
+  "strings"
+  "sync"
+  "time"
 
+  "github.com/dlegs/bounty-hunter/notify"
   "github.com/dlegs/bounty-hunter/storage"
   "github.com/chromedp/chromedp"
+  "github.com/chromedp/cdproto/emulation"
   "github.com/chromedp/cdproto/page"
 )
 
-// Client holds a Chrome context.
+// Strategies for deciding when a page is ready to capture.
+const (
+  // Wait for the load event.
+  WaitLoad = "load"
+  // Wait for the load event and then for the network to go quiet.
+  WaitNetworkIdle = "networkidle"
+  // Wait for the load event and then a fixed delay.
+  WaitDelay = "delay"
+  // Wait for the load event and then for an element to be visible.
+  WaitSelector = "selector"
+)
+
+// How often expired screenshots are pruned.
+const pruneInterval = time.Hour
+
+// Height full page captures are cut off at, so endless pages don't produce
+// huge images.
+const maxFullPageHeight = 16384
+
+// Options configure how pages are loaded and captured.
+type Options struct {
+  // One of the wait strategies.
+  Wait string
+  // Delay of the delay strategy.
+  Delay time.Duration
+  // CSS selector of the selector strategy.
+  Selector string
+  // Maximum time spent loading and capturing each page.
+  Timeout time.Duration
+  // Viewport size, Chrome's default if zero.
+  Width int64
+  Height int64
+  // Whether to capture the whole page rather than the viewport.
+  FullPage bool
+  // User agent sent instead of Chrome's, if set.
+  UserAgent string
+  // Perceptual hash distance from the previous screenshot of a URL at which
+  // the page is reported as changed, never if zero.
+  ChangeThreshold int
+  // Maximum perceptual hash distance between screenshots of pages with the
+  // same title to cluster them, no clustering if zero.
+  ClusterDistance int
+}
+
+// Client holds a Chrome browser and the pool of tabs screenshots are taken in,
+// the db and store screenshots are kept in and slack to report changes to.
 type Client struct {
   ctx context.Context
   cancel context.CancelFunc
+  db *storage.Client
+  slack *notify.Client
+  store Store
+  opts *Options
+  // Pages waiting for a free tab.
+  queue chan *job
+  // Guards the browser, which is replaced when it crashes.
+  mu sync.Mutex
+  browser context.Context
+  browserCancel context.CancelFunc
+  // Serializes clustering, so similar pages captured at once share a cluster.
+  clusterMu sync.Mutex
 }
 
-// New creates a chrome context.
-func New(ctx context.Context) *Client {
-  c := &Client{}
-  c.ctx, c.cancel = chromedp.NewContext(ctx)
+// New starts a pool of tabs workers capturing pages from a queue holding up
+// to queueSize pages. The browser is started on the first capture.
+func New(ctx context.Context, db *storage.Client, slack *notify.Client, store Store, opts *Options, tabs, queueSize int) *Client {
+  c := &Client{
+    db: db,
+    slack: slack,
+    store: store,
+    opts: opts,
+    queue: make(chan *job, queueSize),
+  }
+  c.ctx, c.cancel = context.WithCancel(ctx)
+  for i := 0; i < tabs; i++ {
+    go c.worker()
+  }
   return c
 }
 
+// Close stops the workers and the browser.
 func (c *Client) Close() {
   c.cancel()
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if c.browser != nil {
+    c.browserCancel()
+    c.browser = nil
+  }
 }
 
+// Screenshot captures every web port of the subdomain, queueing the pages
+// behind those of other subdomains until a tab is free.
 func(c *Client) Screenshot(subdomain *storage.Subdomain, done chan bool) {
+  type pending struct {
+    port *storage.Port
+    job *job
+  }
+  queued := []*pending{}
   for _, port := range subdomain.Ports {
-    if port.Service != "http" && port.Service != "https" {
+    url := webURL(port)
+    if url == "" {
       continue
     }
-    var buf []byte
-    scheme := "http"
-    if port.Service == "https" || port.Number == 443 || port.Number == 8443 {
-      scheme = "https"
+    j := &job{
+      url: url,
+      done: make(chan *result, 1),
+    }
+    select {
+    case c.queue <- j:
+      queued = append(queued, &pending{port: port, job: j})
+    case <-c.ctx.Done():
     }
-    url := fmt.Sprintf("%s://%s:%d", scheme, port.Subdomain, port.Number)
-    fileName := fmt.Sprintf("/tmp/%s-%d.png", port.Subdomain, port.Number)
-    if err := chromedp.Run(c.ctx, tasks(url, &buf)); err != nil {
-      log.Fatalf("failed to run chrome tasks: %v", err)
+  }
+  for _, p := range queued {
+    var res *result
+    select {
+    case res = <-p.job.done:
+    case <-c.ctx.Done():
+      continue
+    }
+    if res.err != nil {
+      log.Printf("failed to screenshot %s: %v", p.job.url, res.err)
+      continue
     }
-    if err := ioutil.WriteFile(fileName, buf, 0644); err != nil {
-      log.Fatalf("failed to write image to disk: %v", err)
+    screenshot, err := c.save(p.port, p.job.url, res.title, res.buf)
+    if err != nil {
+      log.Printf("failed to save screenshot of %s: %v", p.job.url, err)
+      continue
     }
-    port.Screenshot = fileName
+    p.port.Screenshot = screenshot
   }
 
   done <- true
 }
 
-func tasks(url string, res *[]byte) chromedp.Tasks {
-  // TODO: possibly wait for page to load?
-  return chromedp.Tasks{
-    chromedp.Navigate(url),
-    chromedp.ActionFunc(func(ctx context.Context) (err error) {
-      *res, err = page.CaptureScreenshot().WithQuality(90).Do(ctx)
+// webURL returns the URL of the web server on the port, or "" if there is
+// none. Ports the HTTP probe answered on are used as probed, and otherwise the
+// service name nmap gave the port is relied on.
+func webURL(port *storage.Port) string {
+  if port.HTTP != nil {
+    return port.HTTP.URL
+  }
+  if port.Service != "http" && port.Service != "https" {
+    return ""
+  }
+  scheme := "http"
+  if port.Service == "https" || port.Number == 443 || port.Number == 8443 {
+    scheme = "https"
+  }
+  return fmt.Sprintf("%s://%s:%d", scheme, port.Subdomain, port.Number)
+}
+
+// save stores the image under its hash and records the screenshot along with
+// how much it differs from the previous one of the URL, reporting pages that
+// changed past the threshold.
+func (c *Client) save(port *storage.Port, url, title string, image []byte) (*storage.Screenshot, error) {
+  sum := sha256.Sum256(image)
+  screenshot := &storage.Screenshot{
+    Subdomain: port.Subdomain,
+    Port: port.Number,
+    URL: url,
+    Hash: hex.EncodeToString(sum[:]),
+    Distance: -1,
+    Title: strings.TrimSpace(title),
+    Image: image,
+  }
+  phash, err := PHash(image)
+  if err != nil {
+    log.Printf("failed to hash screenshot of %s: %v", url, err)
+  }
+  screenshot.PHash = phash
+  previous, err := c.db.LatestScreenshot(url)
+  if err != nil {
+    return nil, err
+  }
+  if previous != nil && previous.PHash != "" && phash != "" {
+    if screenshot.Distance, err = Distance(previous.PHash, phash); err != nil {
+      return nil, err
+    }
+  }
+  location, err := c.store.Put(c.ctx, Key(screenshot), image)
+  if err != nil {
+    return nil, err
+  }
+  screenshot.Location = location
+  if err := c.db.InsertScreenshot(screenshot); err != nil {
+    return nil, err
+  }
+  if c.opts.ClusterDistance > 0 && phash != "" {
+    if err := c.cluster(screenshot); err != nil {
+      return nil, err
+    }
+  }
+  if c.opts.ChangeThreshold > 0 && screenshot.Distance >= c.opts.ChangeThreshold {
+    log.Printf("Page %s changed visually, distance %d", url, screenshot.Distance)
+    if err := c.notifyChange(previous, screenshot); err != nil {
+      log.Printf("failed to notify visual change of %s: %v", url, err)
+    }
+  }
+  return screenshot, nil
+}
+
+// notifyChange fetches the previous image from the store and sends it along
+// with the new one.
+func (c *Client) notifyChange(previous, screenshot *storage.Screenshot) error {
+  image, err := c.store.Get(c.ctx, Key(previous))
+  if err != nil {
+    return err
+  }
+  previous.Image = image
+  return c.slack.NotifyVisualChange(previous, screenshot)
+}
+
+// cluster adds the screenshot to the closest cluster of pages with the same
+// title, or starts a new one if none is close enough.
+func (c *Client) cluster(screenshot *storage.Screenshot) error {
+  c.clusterMu.Lock()
+  defer c.clusterMu.Unlock()
+  clusters, err := c.db.ClustersByTitle(screenshot.Title)
+  if err != nil {
+    return err
+  }
+  var closest *storage.Cluster
+  closestDistance := c.opts.ClusterDistance + 1
+  for _, cluster := range clusters {
+    distance, err := Distance(cluster.PHash, screenshot.PHash)
+    if err != nil {
+      return err
+    }
+    if distance < closestDistance {
+      closest, closestDistance = cluster, distance
+    }
+  }
+  if closest == nil {
+    screenshot.Cluster, err = c.db.InsertCluster(screenshot)
+    return err
+  }
+  screenshot.Cluster = closest
+  return c.db.AddToCluster(closest, screenshot)
+}
+
+// NotifyClusters sends one alert every interval until ctx is done for each
+// cluster that gained screenshots, rather than one per screenshot.
+func (c *Client) NotifyClusters(ctx context.Context, interval time.Duration) {
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+    }
+    clusters, err := c.db.PendingClusters()
+    if err != nil {
+      log.Printf("failed to list pending clusters: %v", err)
+      continue
+    }
+    for _, cluster := range clusters {
+      if err := c.notifyCluster(ctx, cluster); err != nil {
+        log.Printf("failed to notify cluster %d: %v", cluster.ID, err)
+      }
+    }
+  }
+}
+
+// notifyCluster sends the cluster's representative image along with the
+// screenshots added since its last alert.
+func (c *Client) notifyCluster(ctx context.Context, cluster *storage.Cluster) error {
+  screenshots, err := c.db.ClusterScreenshots(cluster)
+  if err != nil {
+    return err
+  }
+  representative, err := c.db.ScreenshotByID(cluster.ScreenshotID)
+  if err != nil {
+    return err
+  }
+  if representative == nil {
+    return fmt.Errorf("representative screenshot %d not found", cluster.ScreenshotID)
+  }
+  if representative.Image, err = c.store.Get(ctx, Key(representative)); err != nil {
+    return err
+  }
+  added := screenshots
+  if cluster.NotifiedSize < len(screenshots) {
+    added = screenshots[cluster.NotifiedSize:]
+  }
+  if err := c.slack.NotifyCluster(cluster, representative, added); err != nil {
+    return err
+  }
+  return c.db.MarkClusterNotified(cluster)
+}
+
+// Key returns the key the image of a screenshot is stored under.
+func Key(screenshot *storage.Screenshot) string {
+  return screenshot.Hash + ".png"
+}
+
+// Prune deletes screenshots older than retention every hour until ctx is
+// done, keeping the latest of each URL. Images are deleted from the store
+// once no screenshot refers to them.
+func (c *Client) Prune(ctx context.Context, retention time.Duration) {
+  ticker := time.NewTicker(pruneInterval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+    }
+    expired, err := c.db.ExpiredScreenshots(time.Now().Add(-retention))
+    if err != nil {
+      log.Printf("failed to list expired screenshots: %v", err)
+      continue
+    }
+    for _, screenshot := range expired {
+      referenced, err := c.db.DeleteScreenshot(screenshot)
       if err != nil {
-        return fmt.Errorf("failed to capture screenshot of page %s: %v", url, err)
+        log.Printf("failed to delete screenshot %d: %v", screenshot.ID, err)
+        continue
+      }
+      if referenced {
+        continue
+      }
+      if err := c.store.Delete(ctx, Key(screenshot)); err != nil {
+        log.Printf("failed to delete screenshot image %s: %v", screenshot.Hash, err)
       }
-      return nil
-    }),
+    }
+    if len(expired) > 0 {
+      log.Printf("Pruned %d screenshots older than %s", len(expired), retention)
+    }
+  }
+}
+
+func tasks(url string, opts *Options, res *[]byte, title *string) chromedp.Tasks {
+  tasks := chromedp.Tasks{}
+  if opts.Width > 0 && opts.Height > 0 {
+    tasks = append(tasks, chromedp.EmulateViewport(opts.Width, opts.Height))
+  }
+  if opts.UserAgent != "" {
+    tasks = append(tasks, emulation.SetUserAgentOverride(opts.UserAgent))
+  }
+
+  idle := make(chan struct{})
+  if opts.Wait == WaitNetworkIdle {
+    tasks = append(tasks,
+      page.SetLifecycleEventsEnabled(true),
+      chromedp.ActionFunc(func(ctx context.Context) error {
+        // Lifecycle events restart with init on every navigation, so only
+        // the network going idle after ours counts.
+        started := false
+        closed := false
+        chromedp.ListenTarget(ctx, func(ev interface{}) {
+          e, ok := ev.(*page.EventLifecycleEvent)
+          if !ok || closed {
+            return
+          }
+          switch e.Name {
+          case "init":
+            started = true
+          case "networkIdle":
+            if started {
+              closed = true
+              close(idle)
+            }
+          }
+        })
+        return nil
+      }),
+    )
   }
+
+  // Navigate waits for the load event.
+  tasks = append(tasks, chromedp.Navigate(url))
+  switch opts.Wait {
+  case WaitNetworkIdle:
+    tasks = append(tasks, chromedp.ActionFunc(func(ctx context.Context) error {
+      select {
+      case <-idle:
+        return nil
+      case <-ctx.Done():
+        return fmt.Errorf("network of page %s never went idle: %v", url, ctx.Err())
+      }
+    }))
+  case WaitDelay:
+    tasks = append(tasks, chromedp.Sleep(opts.Delay))
+  case WaitSelector:
+    tasks = append(tasks, chromedp.WaitVisible(opts.Selector, chromedp.ByQuery))
+  }
+  tasks = append(tasks, chromedp.Title(title))
+
+  return append(tasks, chromedp.ActionFunc(func(ctx context.Context) (err error) {
+    capture := page.CaptureScreenshot().WithQuality(90)
+    if opts.FullPage {
+      _, _, content, err := page.GetLayoutMetrics().Do(ctx)
+      if err != nil {
+        return fmt.Errorf("failed to get layout of page %s: %v", url, err)
+      }
+      width, height := int64(math.Ceil(content.Width)), int64(math.Ceil(content.Height))
+      if height > maxFullPageHeight {
+        height = maxFullPageHeight
+      }
+      if err := emulation.SetDeviceMetricsOverride(width, height, 1, false).Do(ctx); err != nil {
+        return fmt.Errorf("failed to resize page %s: %v", url, err)
+      }
+      capture = capture.WithClip(&page.Viewport{
+        Width: float64(width),
+        Height: float64(height),
+        Scale: 1,
+      })
+    }
+    *res, err = capture.Do(ctx)
+    if err != nil {
+      return fmt.Errorf("failed to capture screenshot of page %s: %v", url, err)
+    }
+    return nil
+  }))
 }
@@ -0,0 +1,212 @@
+package screenshot
+
+import (
+  "bytes"
+  "context"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+// Store keeps screenshot images by key.
+type Store interface {
+  // Put stores the image and returns where it was put.
+  Put(ctx context.Context, key string, image []byte) (string, error)
+  Get(ctx context.Context, key string) ([]byte, error)
+  Delete(ctx context.Context, key string) error
+}
+
+// LocalStore keeps images as files in a directory.
+type LocalStore struct {
+  dir string
+}
+
+// NewLocalStore returns a store writing to dir, creating it if needed.
+func NewLocalStore(dir string) (*LocalStore, error) {
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    return nil, fmt.Errorf("failed to create screenshot directory: %v", err)
+  }
+  return &LocalStore{dir: dir}, nil
+}
+
+// Put writes the image to a file named after the key. Keys are content
+// hashes, so existing files are left alone.
+func (s *LocalStore) Put(ctx context.Context, key string, image []byte) (string, error) {
+  path := filepath.Join(s.dir, key)
+  if _, err := os.Stat(path); err == nil {
+    return path, nil
+  }
+  // Write to a temporary file first so readers never see a partial image.
+  tmp := path + ".tmp"
+  if err := ioutil.WriteFile(tmp, image, 0644); err != nil {
+    return "", fmt.Errorf("failed to write screenshot: %v", err)
+  }
+  if err := os.Rename(tmp, path); err != nil {
+    return "", fmt.Errorf("failed to write screenshot: %v", err)
+  }
+  return path, nil
+}
+
+// Get reads the image stored under key.
+func (s *LocalStore) Get(ctx context.Context, key string) ([]byte, error) {
+  image, err := ioutil.ReadFile(filepath.Join(s.dir, key))
+  if err != nil {
+    return nil, fmt.Errorf("failed to read screenshot: %v", err)
+  }
+  return image, nil
+}
+
+// Delete removes the image stored under key.
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+  if err := os.Remove(filepath.Join(s.dir, key)); err != nil && !os.IsNotExist(err) {
+    return fmt.Errorf("failed to delete screenshot: %v", err)
+  }
+  return nil
+}
+
+// S3Store keeps images in a bucket of an S3-compatible service such as MinIO,
+// using path-style requests signed with AWS Signature Version 4.
+type S3Store struct {
+  // Base URL of the service e.g. http://localhost:9000.
+  endpoint string
+  bucket string
+  region string
+  accessKey string
+  secretKey string
+  http *http.Client
+}
+
+// NewS3Store returns a store writing to bucket at endpoint.
+func NewS3Store(endpoint, bucket, region, accessKey, secretKey string) (*S3Store, error) {
+  if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+    return nil, fmt.Errorf("s3 endpoint %q must start with http:// or https://", endpoint)
+  }
+  if bucket == "" {
+    return nil, fmt.Errorf("s3 bucket is not set")
+  }
+  if accessKey == "" || secretKey == "" {
+    return nil, fmt.Errorf("s3 credentials are not set")
+  }
+  return &S3Store{
+    endpoint: strings.TrimSuffix(endpoint, "/"),
+    bucket: bucket,
+    region: region,
+    accessKey: accessKey,
+    secretKey: secretKey,
+    http: &http.Client{Timeout: time.Minute},
+  }, nil
+}
+
+// Put uploads the image to the bucket.
+func (s *S3Store) Put(ctx context.Context, key string, image []byte) (string, error) {
+  res, err := s.do(ctx, http.MethodPut, key, image)
+  if err != nil {
+    return "", err
+  }
+  res.Body.Close()
+  return fmt.Sprintf("s3://%s/%s", s.bucket, key), nil
+}
+
+// Get downloads the image stored under key.
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+  res, err := s.do(ctx, http.MethodGet, key, nil)
+  if err != nil {
+    return nil, err
+  }
+  defer res.Body.Close()
+  return ioutil.ReadAll(res.Body)
+}
+
+// Delete removes the image stored under key.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+  res, err := s.do(ctx, http.MethodDelete, key, nil)
+  if err != nil {
+    return err
+  }
+  res.Body.Close()
+  return nil
+}
+
+// do sends a signed request for the object and fails on non-2xx responses.
+func (s *S3Store) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+  path := fmt.Sprintf("/%s/%s", s.bucket, key)
+  req, err := http.NewRequestWithContext(ctx, method, s.endpoint+path, bytes.NewReader(body))
+  if err != nil {
+    return nil, fmt.Errorf("failed to create s3 request: %v", err)
+  }
+  if method == http.MethodPut {
+    req.Header.Set("Content-Type", "image/png")
+  }
+  s.sign(req, path, body, time.Now().UTC())
+  res, err := s.http.Do(req)
+  if err != nil {
+    return nil, fmt.Errorf("failed to %s s3 object %s: %v", method, key, err)
+  }
+  if res.StatusCode/100 != 2 {
+    msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+    res.Body.Close()
+    return nil, fmt.Errorf("failed to %s s3 object %s: %s: %s", method, key, res.Status, msg)
+  }
+  return res, nil
+}
+
+// sign adds an AWS Signature Version 4 authorization header to the request,
+// signing the host and every header already set on it.
+func (s *S3Store) sign(req *http.Request, path string, body []byte, now time.Time) {
+  date := now.Format("20060102")
+  amzDate := now.Format("20060102T150405Z")
+  payloadHash := sha256Hex(body)
+  req.Header.Set("X-Amz-Date", amzDate)
+  req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+  names := []string{"host"}
+  values := map[string]string{"host": req.URL.Host}
+  for name, value := range req.Header {
+    name = strings.ToLower(name)
+    names = append(names, name)
+    values[name] = strings.TrimSpace(strings.Join(value, ","))
+  }
+  sort.Strings(names)
+  canonicalHeaders := ""
+  for _, name := range names {
+    canonicalHeaders += name + ":" + values[name] + "\n"
+  }
+  signedHeaders := strings.Join(names, ";")
+  canonicalRequest := strings.Join([]string{
+    req.Method,
+    path,
+    "",
+    canonicalHeaders,
+    signedHeaders,
+    payloadHash,
+  }, "\n")
+  scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+  stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+  key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+  key = hmacSHA256(key, s.region)
+  key = hmacSHA256(key, "s3")
+  key = hmacSHA256(key, "aws4_request")
+  signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+  req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+  sum := sha256.Sum256(data)
+  return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+  mac := hmac.New(sha256.New, key)
+  mac.Write([]byte(data))
+  return mac.Sum(nil)
+}
@@ -5,10 +5,74 @@ package storage
 import (
   "fmt"
   "database/sql"
+  "regexp"
+  "strconv"
+  "strings"
+  "time"
 
   _ "github.com/mattn/go-sqlite3"
 )
 
+// Sources a subdomain can be discovered from.
+const (
+  SourceCertstream = "certstream"
+  SourceCTLog = "ctlog"
+  SourcePermutation = "permutation"
+  SourceBruteforce = "bruteforce"
+  SourceImport = "import"
+  SourcePassive = "passive"
+  SourceAXFR = "axfr"
+)
+
+// Takeover confidence levels, from weakest to strongest.
+const (
+  // Only the CNAME points at a fingerprinted service.
+  ConfidenceCNAME = "cname-only"
+  // The response body matched a fingerprint.
+  ConfidenceBody = "body-match"
+  // The subdomain doesn't resolve and its CNAME points at a service known to
+  // be claimable when dangling.
+  ConfidenceNXDomain = "nxdomain-confirmed"
+  // Every nameserver the subdomain is delegated to refuses or fails to answer
+  // for it.
+  ConfidenceLameDelegation = "lame-delegation"
+  // An MX record of the subdomain points at a name that doesn't exist.
+  ConfidenceMXNXDomain = "mx-nxdomain"
+  // A record of the subdomain points into a domain anyone can register.
+  ConfidenceRegistrable = "domain-available"
+  // A cloud address of the subdomain responded before but no longer does, so
+  // it may have been released.
+  ConfidenceIPUnresponsive = "ip-unresponsive"
+  // A cloud address of the subdomain serves the provider's default page.
+  ConfidenceIPDefaultPage = "ip-default-page"
+)
+
+// Kinds of takeover, by the record left dangling.
+const (
+  TakeoverCNAME = "cname"
+  TakeoverNS = "ns"
+  TakeoverMX = "mx"
+  // Any record pointing into an unregistered domain.
+  TakeoverDomain = "domain"
+  // An A record pointing at a cloud address that may have been released.
+  TakeoverIP = "ip"
+)
+
+// Takeover statuses.
+const (
+  TakeoverOpen = "open"
+  TakeoverVerified = "verified"
+  TakeoverReported = "reported"
+  TakeoverFixed = "fixed"
+)
+
+// Finding severities.
+const (
+  SeverityHigh = "high"
+  SeverityMedium = "medium"
+  SeverityLow = "low"
+)
+
 // Client holds the sql dependency.
 type Client struct {
   db *sql.DB
@@ -26,6 +90,137 @@ type Subdomain struct {
   Domain string
   Ports []*Port
   Takeover string
+  // How the subdomain was first discovered e.g. certstream or bruteforce.
+  Source string
+  // Every source the subdomain has been observed from.
+  Sources []*Source
+  // CDN the subdomain is served from, if any.
+  CDN string
+  // Evidence of the takeover, if any.
+  TakeoverEvidence *Takeover
+}
+
+// Takeover represents a possible subdomain takeover and its evidence.
+type Takeover struct {
+  ID int64
+  Subdomain string
+  // Kind of record left dangling e.g. cname or ns.
+  Kind string
+  Service string
+  Confidence string
+  // CNAME chain of the subdomain in order.
+  CNAMEs []string
+  // URL whose response matched, if any.
+  URL string
+  StatusCode int
+  // Response headers of the matching response.
+  Headers string
+  // Fingerprint string found in the response body.
+  Fingerprint string
+  // Part of the response body surrounding the fingerprint.
+  Excerpt string
+  // NS or MX records of the subdomain and how their targets answered.
+  Records []string
+  Status string
+  FirstSeen time.Time
+  LastSeen time.Time
+}
+
+// Source represents the observations of a subdomain from one discovery source.
+type Source struct {
+  Name string
+  FirstSeen time.Time
+  LastSeen time.Time
+  Count int
+}
+
+// Banner represents the raw response of a service to a probe.
+type Banner struct {
+  Subdomain string
+  Port int
+  Protocol string
+  // Probe sent to the service e.g. passive, http, tls.
+  Probe string
+  Response string
+  GrabbedAt time.Time
+}
+
+// HTTPResponse represents the response of a web server on a port.
+type HTTPResponse struct {
+  Subdomain string
+  Port int
+  // URL requested e.g. https://example.com:8443.
+  URL string
+  StatusCode int
+  // URL of the last response, after following redirects.
+  FinalURL string
+  // URLs redirected to, in order.
+  Redirects []string
+  Title string
+  // Length of the body, from the Content-Length header if set.
+  ContentLength int64
+  // Server header.
+  Server string
+  // All response headers, one per line.
+  Headers string
+  // SHA-256 of the body.
+  BodyHash string
+  ProbedAt time.Time
+  // Body, only set on responses just probed.
+  Body []byte
+}
+
+// Technology represents a technology detected on a web server e.g. Jenkins.
+type Technology struct {
+  Subdomain string
+  Port int
+  Name string
+  // Version, if the rules could tell.
+  Version string
+  Categories []string
+  // Between 1 and 100.
+  Confidence int
+  DetectedAt time.Time
+}
+
+// Favicon represents a favicon served by a web server.
+type Favicon struct {
+  Subdomain string
+  Port int
+  URL string
+  // Hash Shodan indexes favicons by, searchable with http.favicon.hash.
+  MMH3 int32
+  SHA256 string
+  // Product known to serve the favicon, if any.
+  Product string
+  FetchedAt time.Time
+}
+
+// Finding represents an issue found on a domain or subdomain.
+type Finding struct {
+  ID int64
+  // Domain or subdomain the finding applies to.
+  Target string
+  // Kind of finding e.g. axfr.
+  Kind string
+  Severity string
+  Detail string
+  FoundAt time.Time
+}
+
+// CloudIP represents a cloud provider address a subdomain resolves to.
+type CloudIP struct {
+  Subdomain string
+  IP string
+  Provider string
+  Region string
+  Service string
+  // Whether the address answered on the latest check.
+  Responding bool
+  FirstSeen time.Time
+  LastSeen time.Time
+  // Last time the address answered, zero if it never has.
+  LastResponding time.Time
 }
 
 // Port represents a port.
@@ -36,8 +231,60 @@ type Port struct {
   Service string
   Product string
   Version string
-  // File containing a screenshot of the web server
-  Screenshot string
+  // Name of the scan profile the port was found with.
+  Profile string
+  // Raw responses of the service to banner probes.
+  Banners []*Banner
+  // Response of the web server, if any.
+  HTTP *HTTPResponse
+  // Technologies detected in the response of the web server.
+  Technologies []*Technology
+  // Favicons of the web server.
+  Favicons []*Favicon
+  // Screenshot of the web server, if any.
+  Screenshot *Screenshot
+}
+
+// Screenshot represents a stored screenshot of a web server.
+type Screenshot struct {
+  ID int64
+  Subdomain string
+  Port int
+  URL string
+  // SHA-256 of the image, which it is stored under.
+  Hash string
+  // Where the store put the image e.g. a file path or s3:// URL.
+  Location string
+  TakenAt time.Time
+  // Perceptual hash of the image, similar for images that look alike.
+  PHash string
+  // Hamming distance between the perceptual hashes of this and the previous
+  // screenshot of the URL, or -1 if there was none.
+  Distance int
+  // Title of the page.
+  Title string
+  // Cluster of similar screenshots this one belongs to, 0 if none.
+  ClusterID int64
+  // The cluster, only set on screenshots just taken.
+  Cluster *Cluster
+  // PNG image, only set on screenshots just taken.
+  Image []byte
+}
+
+// Cluster is a group of screenshots of pages that look alike and share a
+// title, e.g. many hosts showing the same parking page or SSO login.
+type Cluster struct {
+  ID int64
+  // Perceptual hash and title of the representative screenshot.
+  PHash string
+  Title string
+  // First screenshot of the cluster, shown in alerts.
+  ScreenshotID int64
+  // Number of screenshots in the cluster, and how many had been alerted on.
+  Size int
+  NotifiedSize int
+  FirstSeen time.Time
+  LastSeen time.Time
 }
 
 // New returns a new db client and creates tables if this is the first run.
@@ -55,46 +302,142 @@ func New(dbName string) (*Client, error) {
     return nil, fmt.Errorf("failed creating subdomains table: %v", err)
   }
 
+  if err := addColumn(db, "subdomains", "source", "TEXT"); err != nil {
+    return nil, err
+  }
+
+  if _, err := db.Exec("CREATE TABLE IF NOT EXISTS sources (subdomain TEXT, source TEXT, first_seen TIMESTAMP, last_seen TIMESTAMP, count INTEGER, PRIMARY KEY(subdomain, source), FOREIGN KEY(subdomain) REFERENCES subdomains(subdomain))"); err != nil {
+    return nil, fmt.Errorf("failed creating sources table: %v", err)
+  }
+
+  // A subdomain can have a takeover of each kind on the same service e.g. a
+  // dangling CNAME and NS delegation.
+  if _, err := db.Exec("CREATE TABLE IF NOT EXISTS takeovers (id INTEGER PRIMARY KEY, subdomain TEXT, kind TEXT, service TEXT, confidence TEXT, cnames TEXT, url TEXT, status_code INTEGER, headers TEXT, fingerprint TEXT, excerpt TEXT, records TEXT, status TEXT, first_seen TIMESTAMP, last_seen TIMESTAMP, UNIQUE(subdomain, kind, service), FOREIGN KEY(subdomain) REFERENCES subdomains(subdomain))"); err != nil {
+    return nil, fmt.Errorf("failed creating takeovers table: %v", err)
+  }
+
+  if _, err := db.Exec("CREATE TABLE IF NOT EXISTS findings (id INTEGER PRIMARY KEY, target TEXT, kind TEXT, severity TEXT, detail TEXT, found_at TIMESTAMP)"); err != nil {
+    return nil, fmt.Errorf("failed creating findings table: %v", err)
+  }
+
   if _, err := db.Exec("CREATE TABLE IF NOT EXISTS ports (id INTEGER AUTO_INCREMENT PRIMARY KEY, port INTEGER, subdomain TEXT, protocol TEXT, service TEXT, product TEXT, version TEXT, FOREIGN KEY(subdomain) references subdomains(subdomain))"); err != nil {
     return nil, fmt.Errorf("failed creating ports table: %v", err)
   }
 
+  if _, err := db.Exec("CREATE TABLE IF NOT EXISTS banners (subdomain TEXT, port INTEGER, protocol TEXT, probe TEXT, response TEXT, grabbed_at TIMESTAMP, PRIMARY KEY(subdomain, port, protocol, probe), FOREIGN KEY(subdomain) REFERENCES subdomains(subdomain))"); err != nil {
+    return nil, fmt.Errorf("failed creating banners table: %v", err)
+  }
+
+  if err := addColumn(db, "subdomains", "cdn", "TEXT"); err != nil {
+    return nil, err
+  }
+
+  if err := addColumn(db, "ports", "profile", "TEXT"); err != nil {
+    return nil, err
+  }
+
+  if _, err := db.Exec("CREATE TABLE IF NOT EXISTS cloud_ips (subdomain TEXT, ip TEXT, provider TEXT, region TEXT, service TEXT, responding BOOLEAN, first_seen TIMESTAMP, last_seen TIMESTAMP, last_responding TIMESTAMP, PRIMARY KEY(subdomain, ip), FOREIGN KEY(subdomain) REFERENCES subdomains(subdomain))"); err != nil {
+    return nil, fmt.Errorf("failed creating cloud_ips table: %v", err)
+  }
+
+  if _, err := db.Exec("CREATE TABLE IF NOT EXISTS screenshots (id INTEGER PRIMARY KEY, subdomain TEXT, port INTEGER, url TEXT, hash TEXT, location TEXT, taken_at TIMESTAMP, FOREIGN KEY(subdomain) REFERENCES subdomains(subdomain))"); err != nil {
+    return nil, fmt.Errorf("failed creating screenshots table: %v", err)
+  }
+
+  if err := addColumn(db, "screenshots", "phash", "TEXT"); err != nil {
+    return nil, err
+  }
+
+  if err := addColumn(db, "screenshots", "distance", "INTEGER"); err != nil {
+    return nil, err
+  }
+
+  if err := addColumn(db, "screenshots", "title", "TEXT"); err != nil {
+    return nil, err
+  }
+
+  if err := addColumn(db, "screenshots", "cluster_id", "INTEGER"); err != nil {
+    return nil, err
+  }
+
+  if _, err := db.Exec("CREATE TABLE IF NOT EXISTS http_responses (subdomain TEXT, port INTEGER, url TEXT, status_code INTEGER, final_url TEXT, redirects TEXT, title TEXT, content_length INTEGER, server TEXT, headers TEXT, body_hash TEXT, probed_at TIMESTAMP, PRIMARY KEY(subdomain, port), FOREIGN KEY(subdomain) REFERENCES subdomains(subdomain))"); err != nil {
+    return nil, fmt.Errorf("failed creating http_responses table: %v", err)
+  }
+
+  if _, err := db.Exec("CREATE TABLE IF NOT EXISTS technologies (subdomain TEXT, port INTEGER, name TEXT, version TEXT, categories TEXT, confidence INTEGER, detected_at TIMESTAMP, PRIMARY KEY(subdomain, port, name), FOREIGN KEY(subdomain) REFERENCES subdomains(subdomain))"); err != nil {
+    return nil, fmt.Errorf("failed creating technologies table: %v", err)
+  }
+
+  if _, err := db.Exec("CREATE TABLE IF NOT EXISTS favicons (subdomain TEXT, port INTEGER, url TEXT, mmh3 INTEGER, sha256 TEXT, product TEXT, fetched_at TIMESTAMP, PRIMARY KEY(subdomain, port, url), FOREIGN KEY(subdomain) REFERENCES subdomains(subdomain))"); err != nil {
+    return nil, fmt.Errorf("failed creating favicons table: %v", err)
+  }
+
+  if _, err := db.Exec("CREATE TABLE IF NOT EXISTS clusters (id INTEGER PRIMARY KEY, phash TEXT, title TEXT, screenshot_id INTEGER, size INTEGER, notified_size INTEGER, first_seen TIMESTAMP, last_seen TIMESTAMP, FOREIGN KEY(screenshot_id) REFERENCES screenshots(id))"); err != nil {
+    return nil, fmt.Errorf("failed creating clusters table: %v", err)
+  }
+
   return &Client{
     db: db,
   }, nil
 }
 
-// InsertDomain inserts a domain into the db.
-func (c *Client) InsertDomain(domain *Domain) error {
+// addColumn adds a column to a table created by an earlier version, ignoring
+// the error if it already exists.
+func addColumn(db *sql.DB, table, column, def string) error {
+  if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def)); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+    return fmt.Errorf("failed adding column %s to %s table: %v", column, table, err)
+  }
+  return nil
+}
+
+// InsertDomain inserts a domain into the db and returns whether it is new.
+func (c *Client) InsertDomain(domain *Domain) (bool, error) {
   statement, err := c.db.Prepare("INSERT OR IGNORE INTO domains (domain) VALUES (?)")
   if err != nil {
-    return fmt.Errorf("failed to prepare insert statement: %v", err)
+    return false, fmt.Errorf("failed to prepare insert statement: %v", err)
   }
-  if _, err := statement.Exec(domain.Name); err != nil {
-    return fmt.Errorf("failed to execute insert statement: %v", err)
+  res, err := statement.Exec(domain.Name)
+  if err != nil {
+    return false, fmt.Errorf("failed to execute insert statement: %v", err)
   }
-  return nil
+  inserted, err := res.RowsAffected()
+  if err != nil {
+    return false, fmt.Errorf("failed to get affected rows: %v", err)
+  }
+  return inserted > 0, nil
 }
 
 // InsertSubdomain inserts a subdomain into the db.
 func (c *Client) InsertSubdomain(subdomain *Subdomain) error {
-  statement, err := c.db.Prepare("INSERT OR IGNORE INTO subdomains (subdomain, domain, takeover) VALUES (?, ?, ?)")
+  statement, err := c.db.Prepare("INSERT OR IGNORE INTO subdomains (subdomain, domain, takeover, source) VALUES (?, ?, ?, ?)")
   if err != nil {
     return fmt.Errorf("failed to prepare insert statement: %v", err)
   }
-  if _, err := statement.Exec(subdomain.Name, subdomain.Domain, subdomain.Takeover); err != nil {
+  if _, err := statement.Exec(subdomain.Name, subdomain.Domain, subdomain.Takeover, subdomain.Source); err != nil {
     return fmt.Errorf("failed to execute insert statement: %v", err)
   }
   return nil
 }
 
+// UpdateCDN updates the CDN a subdomain is served from.
+func (c *Client) UpdateCDN(subdomain *Subdomain) error {
+  statement, err := c.db.Prepare("UPDATE subdomains SET cdn = ? WHERE subdomain = ?")
+  if err != nil {
+    return fmt.Errorf("failed to prepare update statement: %v", err)
+  }
+  if _, err := statement.Exec(subdomain.CDN, subdomain.Name); err != nil {
+    return fmt.Errorf("failed to execute update statement: %v", err)
+  }
+  return nil
+}
+
 // InsertPort inserts a port into the db.
 func (c *Client) InsertPort(port *Port) error {
-  statement, err := c.db.Prepare("INSERT INTO ports (port, subdomain, protocol, service, product, version) VALUES (?, ?, ?, ?, ?, ?)")
+  statement, err := c.db.Prepare("INSERT INTO ports (port, subdomain, protocol, service, product, version, profile) VALUES (?, ?, ?, ?, ?, ?, ?)")
   if err != nil {
     return fmt.Errorf("failed to prepare insert statement: %v", err)
   }
-  if _, err := statement.Exec(port.Number, port.Subdomain, port.Protocol, port.Service, port.Product, port.Version); err != nil {
+  if _, err := statement.Exec(port.Number, port.Subdomain, port.Protocol, port.Service, port.Product, port.Version, port.Profile); err != nil {
     return fmt.Errorf("failed to execute insert statement: %v", err)
   }
   return nil
@@ -133,3 +476,614 @@ func (c *Client) PortExists(port *Port) (bool, error) {
   }
   return true, nil
 }
+
+// Subdomains returns all subdomains stored under a domain.
+func (c *Client) Subdomains(domain string) ([]*Subdomain, error) {
+  rows, err := c.db.Query("SELECT subdomain, domain, takeover, IFNULL(source, '') FROM subdomains WHERE domain = ?", domain)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query subdomains: %v", err)
+  }
+  defer rows.Close()
+  subdomains := []*Subdomain{}
+  for rows.Next() {
+    subdomain := &Subdomain{}
+    if err := rows.Scan(&subdomain.Name, &subdomain.Domain, &subdomain.Takeover, &subdomain.Source); err != nil {
+      return nil, fmt.Errorf("failed to scan subdomain row: %v", err)
+    }
+    subdomains = append(subdomains, subdomain)
+  }
+  return subdomains, rows.Err()
+}
+
+// Domains returns all stored domains.
+func (c *Client) Domains() ([]*Domain, error) {
+  rows, err := c.db.Query("SELECT domain FROM domains")
+  if err != nil {
+    return nil, fmt.Errorf("failed to query domains: %v", err)
+  }
+  defer rows.Close()
+  domains := []*Domain{}
+  for rows.Next() {
+    domain := &Domain{}
+    if err := rows.Scan(&domain.Name); err != nil {
+      return nil, fmt.Errorf("failed to scan domain row: %v", err)
+    }
+    domains = append(domains, domain)
+  }
+  return domains, rows.Err()
+}
+
+// RecordSource records an observation of a subdomain from a source.
+func (c *Client) RecordSource(subdomain *Subdomain, source string) error {
+  statement, err := c.db.Prepare("INSERT INTO sources (subdomain, source, first_seen, last_seen, count) VALUES (?, ?, ?, ?, 1) ON CONFLICT(subdomain, source) DO UPDATE SET last_seen = excluded.last_seen, count = count + 1")
+  if err != nil {
+    return fmt.Errorf("failed to prepare upsert statement: %v", err)
+  }
+  now := time.Now().UTC()
+  if _, err := statement.Exec(subdomain.Name, source, now, now); err != nil {
+    return fmt.Errorf("failed to execute upsert statement: %v", err)
+  }
+  return nil
+}
+
+// Sources returns every source a subdomain has been observed from.
+func (c *Client) Sources(subdomain *Subdomain) ([]*Source, error) {
+  rows, err := c.db.Query("SELECT source, first_seen, last_seen, count FROM sources WHERE subdomain = ? ORDER BY first_seen", subdomain.Name)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query sources: %v", err)
+  }
+  defer rows.Close()
+  sources := []*Source{}
+  for rows.Next() {
+    source := &Source{}
+    if err := rows.Scan(&source.Name, &source.FirstSeen, &source.LastSeen, &source.Count); err != nil {
+      return nil, fmt.Errorf("failed to scan source row: %v", err)
+    }
+    sources = append(sources, source)
+  }
+  return sources, rows.Err()
+}
+
+// SubdomainFilter narrows down the subdomains returned by FindSubdomains. Empty
+// fields match everything.
+type SubdomainFilter struct {
+  Domain string
+  // Only match subdomains observed from this source.
+  Source string
+  // Only match subdomains observed since this time.
+  Since time.Time
+}
+
+// FindSubdomains returns the subdomains matching the filter along with their
+// sources.
+func (c *Client) FindSubdomains(filter *SubdomainFilter) ([]*Subdomain, error) {
+  query := "SELECT DISTINCT s.subdomain, s.domain, s.takeover, IFNULL(s.source, '') FROM subdomains s LEFT JOIN sources o ON o.subdomain = s.subdomain WHERE 1 = 1"
+  args := []interface{}{}
+  if filter.Domain != "" {
+    query += " AND s.domain = ?"
+    args = append(args, filter.Domain)
+  }
+  if filter.Source != "" {
+    query += " AND o.source = ?"
+    args = append(args, filter.Source)
+  }
+  if !filter.Since.IsZero() {
+    query += " AND o.last_seen >= ?"
+    args = append(args, filter.Since.UTC())
+  }
+  rows, err := c.db.Query(query+" ORDER BY s.subdomain", args...)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query subdomains: %v", err)
+  }
+  subdomains := []*Subdomain{}
+  for rows.Next() {
+    subdomain := &Subdomain{}
+    if err := rows.Scan(&subdomain.Name, &subdomain.Domain, &subdomain.Takeover, &subdomain.Source); err != nil {
+      rows.Close()
+      return nil, fmt.Errorf("failed to scan subdomain row: %v", err)
+    }
+    subdomains = append(subdomains, subdomain)
+  }
+  rows.Close()
+  if err := rows.Err(); err != nil {
+    return nil, fmt.Errorf("failed to iterate subdomain rows: %v", err)
+  }
+  for _, subdomain := range subdomains {
+    if subdomain.Sources, err = c.Sources(subdomain); err != nil {
+      return nil, err
+    }
+  }
+  return subdomains, nil
+}
+
+// InsertFinding inserts a finding into the db.
+func (c *Client) InsertFinding(finding *Finding) error {
+  statement, err := c.db.Prepare("INSERT INTO findings (target, kind, severity, detail, found_at) VALUES (?, ?, ?, ?, ?)")
+  if err != nil {
+    return fmt.Errorf("failed to prepare insert statement: %v", err)
+  }
+  if finding.FoundAt.IsZero() {
+    finding.FoundAt = time.Now().UTC()
+  }
+  res, err := statement.Exec(finding.Target, finding.Kind, finding.Severity, finding.Detail, finding.FoundAt)
+  if err != nil {
+    return fmt.Errorf("failed to execute insert statement: %v", err)
+  }
+  if finding.ID, err = res.LastInsertId(); err != nil {
+    return fmt.Errorf("failed to get inserted finding id: %v", err)
+  }
+  return nil
+}
+
+// InsertBanner inserts a banner into the db, replacing the previous response to
+// the same probe.
+func (c *Client) InsertBanner(banner *Banner) error {
+  statement, err := c.db.Prepare("INSERT OR REPLACE INTO banners (subdomain, port, protocol, probe, response, grabbed_at) VALUES (?, ?, ?, ?, ?, ?)")
+  if err != nil {
+    return fmt.Errorf("failed to prepare insert statement: %v", err)
+  }
+  if banner.GrabbedAt.IsZero() {
+    banner.GrabbedAt = time.Now().UTC()
+  }
+  if _, err := statement.Exec(banner.Subdomain, banner.Port, banner.Protocol, banner.Probe, banner.Response, banner.GrabbedAt); err != nil {
+    return fmt.Errorf("failed to execute insert statement: %v", err)
+  }
+  return nil
+}
+
+// InsertHTTPResponse inserts the response of a port into the db, replacing the
+// previous one.
+func (c *Client) InsertHTTPResponse(response *HTTPResponse) error {
+  statement, err := c.db.Prepare("INSERT OR REPLACE INTO http_responses (subdomain, port, url, status_code, final_url, redirects, title, content_length, server, headers, body_hash, probed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+  if err != nil {
+    return fmt.Errorf("failed to prepare insert statement: %v", err)
+  }
+  if response.ProbedAt.IsZero() {
+    response.ProbedAt = time.Now().UTC()
+  }
+  if _, err := statement.Exec(response.Subdomain, response.Port, response.URL, response.StatusCode, response.FinalURL, strings.Join(response.Redirects, "\n"), response.Title, response.ContentLength, response.Server, response.Headers, response.BodyHash, response.ProbedAt); err != nil {
+    return fmt.Errorf("failed to execute insert statement: %v", err)
+  }
+  return nil
+}
+
+// HTTPResponse returns the latest response of a port, or nil if it hasn't
+// been probed.
+func (c *Client) HTTPResponse(subdomain string, port int) (*HTTPResponse, error) {
+  r := &HTTPResponse{}
+  var redirects string
+  err := c.db.QueryRow("SELECT subdomain, port, url, status_code, final_url, redirects, title, content_length, server, headers, body_hash, probed_at FROM http_responses WHERE subdomain = ? AND port = ?", subdomain, port).Scan(&r.Subdomain, &r.Port, &r.URL, &r.StatusCode, &r.FinalURL, &redirects, &r.Title, &r.ContentLength, &r.Server, &r.Headers, &r.BodyHash, &r.ProbedAt)
+  if err == sql.ErrNoRows {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, fmt.Errorf("failed to query http response: %v", err)
+  }
+  if redirects != "" {
+    r.Redirects = strings.Split(redirects, "\n")
+  }
+  return r, nil
+}
+
+// ReplaceTechnologies replaces the technologies stored for a port.
+func (c *Client) ReplaceTechnologies(port *Port, technologies []*Technology) error {
+  tx, err := c.db.Begin()
+  if err != nil {
+    return fmt.Errorf("failed to begin transaction: %v", err)
+  }
+  defer tx.Rollback()
+  if _, err := tx.Exec("DELETE FROM technologies WHERE subdomain = ? AND port = ?", port.Subdomain, port.Number); err != nil {
+    return fmt.Errorf("failed to delete technologies: %v", err)
+  }
+  now := time.Now().UTC()
+  for _, t := range technologies {
+    t.DetectedAt = now
+    if _, err := tx.Exec("INSERT INTO technologies (subdomain, port, name, version, categories, confidence, detected_at) VALUES (?, ?, ?, ?, ?, ?, ?)", port.Subdomain, port.Number, t.Name, t.Version, strings.Join(t.Categories, ","), t.Confidence, t.DetectedAt); err != nil {
+      return fmt.Errorf("failed to insert technology: %v", err)
+    }
+  }
+  if err := tx.Commit(); err != nil {
+    return fmt.Errorf("failed to commit technologies: %v", err)
+  }
+  return nil
+}
+
+// Technologies returns the technologies detected on a port.
+func (c *Client) Technologies(subdomain string, port int) ([]*Technology, error) {
+  rows, err := c.db.Query("SELECT subdomain, port, name, version, categories, confidence, detected_at FROM technologies WHERE subdomain = ? AND port = ? ORDER BY name", subdomain, port)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query technologies: %v", err)
+  }
+  defer rows.Close()
+  technologies := []*Technology{}
+  for rows.Next() {
+    t := &Technology{}
+    var categories string
+    if err := rows.Scan(&t.Subdomain, &t.Port, &t.Name, &t.Version, &categories, &t.Confidence, &t.DetectedAt); err != nil {
+      return nil, fmt.Errorf("failed to scan technology row: %v", err)
+    }
+    if categories != "" {
+      t.Categories = strings.Split(categories, ",")
+    }
+    technologies = append(technologies, t)
+  }
+  return technologies, rows.Err()
+}
+
+// ReplaceFavicons replaces the favicons stored for a port.
+func (c *Client) ReplaceFavicons(port *Port, favicons []*Favicon) error {
+  tx, err := c.db.Begin()
+  if err != nil {
+    return fmt.Errorf("failed to begin transaction: %v", err)
+  }
+  defer tx.Rollback()
+  if _, err := tx.Exec("DELETE FROM favicons WHERE subdomain = ? AND port = ?", port.Subdomain, port.Number); err != nil {
+    return fmt.Errorf("failed to delete favicons: %v", err)
+  }
+  now := time.Now().UTC()
+  for _, f := range favicons {
+    f.FetchedAt = now
+    if _, err := tx.Exec("INSERT OR REPLACE INTO favicons (subdomain, port, url, mmh3, sha256, product, fetched_at) VALUES (?, ?, ?, ?, ?, ?, ?)", port.Subdomain, port.Number, f.URL, f.MMH3, f.SHA256, f.Product, f.FetchedAt); err != nil {
+      return fmt.Errorf("failed to insert favicon: %v", err)
+    }
+  }
+  if err := tx.Commit(); err != nil {
+    return fmt.Errorf("failed to commit favicons: %v", err)
+  }
+  return nil
+}
+
+// SearchFavicons returns every favicon with the hash, either an mmh3 hash or a
+// sha256 hex digest.
+func (c *Client) SearchFavicons(hash string) ([]*Favicon, error) {
+  query := "SELECT subdomain, port, url, mmh3, sha256, product, fetched_at FROM favicons WHERE sha256 = ?"
+  args := []interface{}{strings.ToLower(hash)}
+  if mmh3, err := strconv.ParseInt(hash, 10, 32); err == nil {
+    query = "SELECT subdomain, port, url, mmh3, sha256, product, fetched_at FROM favicons WHERE mmh3 = ?"
+    args = []interface{}{mmh3}
+  }
+  rows, err := c.db.Query(query+" ORDER BY subdomain, port", args...)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query favicons: %v", err)
+  }
+  defer rows.Close()
+  favicons := []*Favicon{}
+  for rows.Next() {
+    f := &Favicon{}
+    if err := rows.Scan(&f.Subdomain, &f.Port, &f.URL, &f.MMH3, &f.SHA256, &f.Product, &f.FetchedAt); err != nil {
+      return nil, fmt.Errorf("failed to scan favicon row: %v", err)
+    }
+    favicons = append(favicons, f)
+  }
+  return favicons, rows.Err()
+}
+
+// SearchBanners returns every banner whose response matches the regex.
+func (c *Client) SearchBanners(re *regexp.Regexp) ([]*Banner, error) {
+  rows, err := c.db.Query("SELECT subdomain, port, protocol, probe, response, grabbed_at FROM banners ORDER BY subdomain, port, probe")
+  if err != nil {
+    return nil, fmt.Errorf("failed to query banners: %v", err)
+  }
+  defer rows.Close()
+  banners := []*Banner{}
+  for rows.Next() {
+    banner := &Banner{}
+    if err := rows.Scan(&banner.Subdomain, &banner.Port, &banner.Protocol, &banner.Probe, &banner.Response, &banner.GrabbedAt); err != nil {
+      return nil, fmt.Errorf("failed to scan banner row: %v", err)
+    }
+    if re.MatchString(banner.Response) {
+      banners = append(banners, banner)
+    }
+  }
+  return banners, rows.Err()
+}
+
+// UpsertTakeover inserts a takeover, or refreshes the evidence and last seen
+// time of an existing one for the same subdomain, kind and service. A takeover
+// previously marked fixed is reopened.
+func (c *Client) UpsertTakeover(takeover *Takeover) error {
+  if takeover.Kind == "" {
+    takeover.Kind = TakeoverCNAME
+  }
+  statement, err := c.db.Prepare("INSERT INTO takeovers (subdomain, kind, service, confidence, cnames, url, status_code, headers, fingerprint, excerpt, records, status, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(subdomain, kind, service) DO UPDATE SET confidence = excluded.confidence, cnames = excluded.cnames, records = excluded.records, url = excluded.url, status_code = excluded.status_code, headers = excluded.headers, fingerprint = excluded.fingerprint, excerpt = excluded.excerpt, last_seen = excluded.last_seen, status = CASE WHEN status = ? THEN ? ELSE status END")
+  if err != nil {
+    return fmt.Errorf("failed to prepare upsert statement: %v", err)
+  }
+  now := time.Now().UTC()
+  if _, err := statement.Exec(takeover.Subdomain, takeover.Kind, takeover.Service, takeover.Confidence, strings.Join(takeover.CNAMEs, ","), takeover.URL, takeover.StatusCode, takeover.Headers, takeover.Fingerprint, takeover.Excerpt, strings.Join(takeover.Records, "\n"), TakeoverOpen, now, now, TakeoverFixed, TakeoverOpen); err != nil {
+    return fmt.Errorf("failed to execute upsert statement: %v", err)
+  }
+  return c.db.QueryRow("SELECT id, status, first_seen, last_seen FROM takeovers WHERE subdomain = ? AND kind = ? AND service = ?", takeover.Subdomain, takeover.Kind, takeover.Service).Scan(&takeover.ID, &takeover.Status, &takeover.FirstSeen, &takeover.LastSeen)
+}
+
+// FixTakeovers marks every unfixed takeover of a kind on a subdomain as fixed.
+func (c *Client) FixTakeovers(subdomain *Subdomain, kind string) error {
+  statement, err := c.db.Prepare("UPDATE takeovers SET status = ? WHERE subdomain = ? AND IFNULL(kind, 'cname') = ? AND status != ?")
+  if err != nil {
+    return fmt.Errorf("failed to prepare update statement: %v", err)
+  }
+  if _, err := statement.Exec(TakeoverFixed, subdomain.Name, kind, TakeoverFixed); err != nil {
+    return fmt.Errorf("failed to execute update statement: %v", err)
+  }
+  return nil
+}
+
+// SetTakeoverStatus sets the status of a takeover.
+func (c *Client) SetTakeoverStatus(id int64, status string) error {
+  switch status {
+  case TakeoverOpen, TakeoverVerified, TakeoverReported, TakeoverFixed:
+  default:
+    return fmt.Errorf("unknown takeover status %q", status)
+  }
+  res, err := c.db.Exec("UPDATE takeovers SET status = ? WHERE id = ?", status, id)
+  if err != nil {
+    return fmt.Errorf("failed to update takeover status: %v", err)
+  }
+  if n, err := res.RowsAffected(); err != nil || n == 0 {
+    return fmt.Errorf("takeover %d not found", id)
+  }
+  return nil
+}
+
+// Takeovers returns the takeovers with the given status, or all if empty.
+func (c *Client) Takeovers(status string) ([]*Takeover, error) {
+  rows, err := c.db.Query("SELECT id, subdomain, IFNULL(kind, 'cname'), service, confidence, cnames, url, status_code, headers, fingerprint, excerpt, IFNULL(records, ''), status, first_seen, last_seen FROM takeovers WHERE ? = '' OR status = ? ORDER BY last_seen DESC", status, status)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query takeovers: %v", err)
+  }
+  defer rows.Close()
+  takeovers := []*Takeover{}
+  for rows.Next() {
+    t := &Takeover{}
+    var cnames, records string
+    if err := rows.Scan(&t.ID, &t.Subdomain, &t.Kind, &t.Service, &t.Confidence, &cnames, &t.URL, &t.StatusCode, &t.Headers, &t.Fingerprint, &t.Excerpt, &records, &t.Status, &t.FirstSeen, &t.LastSeen); err != nil {
+      return nil, fmt.Errorf("failed to scan takeover row: %v", err)
+    }
+    if cnames != "" {
+      t.CNAMEs = strings.Split(cnames, ",")
+    }
+    if records != "" {
+      t.Records = strings.Split(records, "\n")
+    }
+    takeovers = append(takeovers, t)
+  }
+  return takeovers, rows.Err()
+}
+
+// UpsertCloudIP records a check of a cloud address of a subdomain. It returns
+// when the address last responded before this check, zero if never.
+func (c *Client) UpsertCloudIP(ip *CloudIP) (time.Time, error) {
+  var previous sql.NullTime
+  if err := c.db.QueryRow("SELECT last_responding FROM cloud_ips WHERE subdomain = ? AND ip = ?", ip.Subdomain, ip.IP).Scan(&previous); err != nil && err != sql.ErrNoRows {
+    return time.Time{}, fmt.Errorf("failed to query cloud ip: %v", err)
+  }
+  statement, err := c.db.Prepare("INSERT INTO cloud_ips (subdomain, ip, provider, region, service, responding, first_seen, last_seen, last_responding) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(subdomain, ip) DO UPDATE SET provider = excluded.provider, region = excluded.region, service = excluded.service, responding = excluded.responding, last_seen = excluded.last_seen, last_responding = IFNULL(excluded.last_responding, last_responding)")
+  if err != nil {
+    return time.Time{}, fmt.Errorf("failed to prepare upsert statement: %v", err)
+  }
+  now := time.Now().UTC()
+  var responding sql.NullTime
+  if ip.Responding {
+    responding = sql.NullTime{Time: now, Valid: true}
+  }
+  if _, err := statement.Exec(ip.Subdomain, ip.IP, ip.Provider, ip.Region, ip.Service, ip.Responding, now, now, responding); err != nil {
+    return time.Time{}, fmt.Errorf("failed to execute upsert statement: %v", err)
+  }
+  var lastResponding sql.NullTime
+  if err := c.db.QueryRow("SELECT first_seen, last_seen, last_responding FROM cloud_ips WHERE subdomain = ? AND ip = ?", ip.Subdomain, ip.IP).Scan(&ip.FirstSeen, &ip.LastSeen, &lastResponding); err != nil {
+    return time.Time{}, fmt.Errorf("failed to query cloud ip: %v", err)
+  }
+  ip.LastResponding = lastResponding.Time
+  return previous.Time, nil
+}
+
+// CloudIPSubdomains returns the subdomains that have resolved to a cloud
+// address.
+func (c *Client) CloudIPSubdomains() ([]*Subdomain, error) {
+  rows, err := c.db.Query("SELECT DISTINCT s.subdomain, s.domain FROM subdomains s JOIN cloud_ips i ON i.subdomain = s.subdomain")
+  if err != nil {
+    return nil, fmt.Errorf("failed to query cloud ip subdomains: %v", err)
+  }
+  defer rows.Close()
+  subdomains := []*Subdomain{}
+  for rows.Next() {
+    subdomain := &Subdomain{}
+    if err := rows.Scan(&subdomain.Name, &subdomain.Domain); err != nil {
+      return nil, fmt.Errorf("failed to scan subdomain row: %v", err)
+    }
+    subdomains = append(subdomains, subdomain)
+  }
+  return subdomains, rows.Err()
+}
+
+// InsertScreenshot inserts a screenshot and fills in its ID and time taken.
+func (c *Client) InsertScreenshot(screenshot *Screenshot) error {
+  statement, err := c.db.Prepare("INSERT INTO screenshots (subdomain, port, url, hash, location, taken_at, phash, distance, title) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+  if err != nil {
+    return fmt.Errorf("failed to prepare insert statement: %v", err)
+  }
+  screenshot.TakenAt = time.Now().UTC()
+  res, err := statement.Exec(screenshot.Subdomain, screenshot.Port, screenshot.URL, screenshot.Hash, screenshot.Location, screenshot.TakenAt, screenshot.PHash, screenshot.Distance, screenshot.Title)
+  if err != nil {
+    return fmt.Errorf("failed to execute insert statement: %v", err)
+  }
+  screenshot.ID, err = res.LastInsertId()
+  return err
+}
+
+// ExpiredScreenshots returns the screenshots taken before the cutoff, except
+// the latest of each URL, which is kept to compare the next one against, and
+// those representing a cluster.
+func (c *Client) ExpiredScreenshots(before time.Time) ([]*Screenshot, error) {
+  rows, err := c.db.Query("SELECT "+screenshotColumns+" FROM screenshots s WHERE taken_at < ? AND id != (SELECT MAX(id) FROM screenshots WHERE url = s.url) AND id NOT IN (SELECT screenshot_id FROM clusters)", before.UTC())
+  if err != nil {
+    return nil, fmt.Errorf("failed to query expired screenshots: %v", err)
+  }
+  defer rows.Close()
+  return scanScreenshots(rows)
+}
+
+// LatestScreenshot returns the last screenshot taken of the URL, or nil if
+// there is none.
+func (c *Client) LatestScreenshot(url string) (*Screenshot, error) {
+  rows, err := c.db.Query("SELECT "+screenshotColumns+" FROM screenshots WHERE url = ? ORDER BY id DESC LIMIT 1", url)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query latest screenshot: %v", err)
+  }
+  defer rows.Close()
+  screenshots, err := scanScreenshots(rows)
+  if err != nil || len(screenshots) == 0 {
+    return nil, err
+  }
+  return screenshots[0], nil
+}
+
+// LatestScreenshots returns the last screenshot taken of each URL, ordered by
+// subdomain and port.
+func (c *Client) LatestScreenshots() ([]*Screenshot, error) {
+  rows, err := c.db.Query("SELECT "+screenshotColumns+" FROM screenshots s WHERE id = (SELECT MAX(id) FROM screenshots WHERE url = s.url) ORDER BY subdomain, port")
+  if err != nil {
+    return nil, fmt.Errorf("failed to query latest screenshots: %v", err)
+  }
+  defer rows.Close()
+  return scanScreenshots(rows)
+}
+
+// DeleteScreenshot deletes a screenshot and returns whether any other
+// screenshot still refers to its image.
+func (c *Client) DeleteScreenshot(screenshot *Screenshot) (bool, error) {
+  if _, err := c.db.Exec("DELETE FROM screenshots WHERE id = ?", screenshot.ID); err != nil {
+    return false, fmt.Errorf("failed to delete screenshot: %v", err)
+  }
+  var count int
+  if err := c.db.QueryRow("SELECT COUNT(*) FROM screenshots WHERE hash = ?", screenshot.Hash).Scan(&count); err != nil {
+    return false, fmt.Errorf("failed to count screenshots: %v", err)
+  }
+  return count > 0, nil
+}
+
+// Columns read by scanScreenshots. Screenshots taken before perceptual
+// hashing have none and no distance.
+const screenshotColumns = "id, subdomain, port, url, hash, location, taken_at, IFNULL(phash, ''), IFNULL(distance, -1), IFNULL(title, ''), IFNULL(cluster_id, 0)"
+
+func scanScreenshots(rows *sql.Rows) ([]*Screenshot, error) {
+  screenshots := []*Screenshot{}
+  for rows.Next() {
+    s := &Screenshot{}
+    if err := rows.Scan(&s.ID, &s.Subdomain, &s.Port, &s.URL, &s.Hash, &s.Location, &s.TakenAt, &s.PHash, &s.Distance, &s.Title, &s.ClusterID); err != nil {
+      return nil, fmt.Errorf("failed to scan screenshot row: %v", err)
+    }
+    screenshots = append(screenshots, s)
+  }
+  return screenshots, rows.Err()
+}
+
+// ScreenshotByID returns the screenshot with the id, or nil if there is none.
+func (c *Client) ScreenshotByID(id int64) (*Screenshot, error) {
+  rows, err := c.db.Query("SELECT "+screenshotColumns+" FROM screenshots WHERE id = ?", id)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query screenshot: %v", err)
+  }
+  defer rows.Close()
+  screenshots, err := scanScreenshots(rows)
+  if err != nil || len(screenshots) == 0 {
+    return nil, err
+  }
+  return screenshots[0], nil
+}
+
+// InsertCluster starts a cluster holding just the screenshot, which
+// represents it. The screenshot itself is shown when its subdomain is
+// notified, so it counts as alerted on.
+func (c *Client) InsertCluster(screenshot *Screenshot) (*Cluster, error) {
+  now := time.Now().UTC()
+  cluster := &Cluster{
+    PHash: screenshot.PHash,
+    Title: screenshot.Title,
+    ScreenshotID: screenshot.ID,
+    Size: 1,
+    NotifiedSize: 1,
+    FirstSeen: now,
+    LastSeen: now,
+  }
+  res, err := c.db.Exec("INSERT INTO clusters (phash, title, screenshot_id, size, notified_size, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?)", cluster.PHash, cluster.Title, cluster.ScreenshotID, cluster.Size, cluster.NotifiedSize, cluster.FirstSeen, cluster.LastSeen)
+  if err != nil {
+    return nil, fmt.Errorf("failed to insert cluster: %v", err)
+  }
+  if cluster.ID, err = res.LastInsertId(); err != nil {
+    return nil, err
+  }
+  screenshot.ClusterID = cluster.ID
+  if _, err := c.db.Exec("UPDATE screenshots SET cluster_id = ? WHERE id = ?", cluster.ID, screenshot.ID); err != nil {
+    return nil, fmt.Errorf("failed to update screenshot cluster: %v", err)
+  }
+  return cluster, nil
+}
+
+// AddToCluster adds the screenshot to the cluster.
+func (c *Client) AddToCluster(cluster *Cluster, screenshot *Screenshot) error {
+  cluster.Size++
+  cluster.LastSeen = time.Now().UTC()
+  if _, err := c.db.Exec("UPDATE clusters SET size = size + 1, last_seen = ? WHERE id = ?", cluster.LastSeen, cluster.ID); err != nil {
+    return fmt.Errorf("failed to update cluster: %v", err)
+  }
+  screenshot.ClusterID = cluster.ID
+  if _, err := c.db.Exec("UPDATE screenshots SET cluster_id = ? WHERE id = ?", cluster.ID, screenshot.ID); err != nil {
+    return fmt.Errorf("failed to update screenshot cluster: %v", err)
+  }
+  return nil
+}
+
+// ClustersByTitle returns the clusters of pages with the title.
+func (c *Client) ClustersByTitle(title string) ([]*Cluster, error) {
+  return c.queryClusters("WHERE title = ?", title)
+}
+
+// Clusters returns every cluster, largest first.
+func (c *Client) Clusters() ([]*Cluster, error) {
+  return c.queryClusters("ORDER BY size DESC")
+}
+
+// PendingClusters returns the clusters that gained screenshots since they were
+// last alerted on.
+func (c *Client) PendingClusters() ([]*Cluster, error) {
+  return c.queryClusters("WHERE size > notified_size")
+}
+
+// MarkClusterNotified records that every screenshot of the cluster has been
+// alerted on.
+func (c *Client) MarkClusterNotified(cluster *Cluster) error {
+  if _, err := c.db.Exec("UPDATE clusters SET notified_size = ? WHERE id = ?", cluster.Size, cluster.ID); err != nil {
+    return fmt.Errorf("failed to update cluster: %v", err)
+  }
+  cluster.NotifiedSize = cluster.Size
+  return nil
+}
+
+// ClusterScreenshots returns the screenshots of the cluster in the order they
+// joined it.
+func (c *Client) ClusterScreenshots(cluster *Cluster) ([]*Screenshot, error) {
+  rows, err := c.db.Query("SELECT "+screenshotColumns+" FROM screenshots WHERE cluster_id = ? ORDER BY id", cluster.ID)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query cluster screenshots: %v", err)
+  }
+  defer rows.Close()
+  return scanScreenshots(rows)
+}
+
+func (c *Client) queryClusters(clause string, args ...interface{}) ([]*Cluster, error) {
+  rows, err := c.db.Query("SELECT id, phash, title, screenshot_id, size, notified_size, first_seen, last_seen FROM clusters "+clause, args...)
+  if err != nil {
+    return nil, fmt.Errorf("failed to query clusters: %v", err)
+  }
+  defer rows.Close()
+  clusters := []*Cluster{}
+  for rows.Next() {
+    cl := &Cluster{}
+    if err := rows.Scan(&cl.ID, &cl.PHash, &cl.Title, &cl.ScreenshotID, &cl.Size, &cl.NotifiedSize, &cl.FirstSeen, &cl.LastSeen); err != nil {
+      return nil, fmt.Errorf("failed to scan cluster row: %v", err)
+    }
+    clusters = append(clusters, cl)
+  }
+  return clusters, rows.Err()
+}
@@ -0,0 +1,186 @@
+package takeover
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "net"
+  "strings"
+
+  "github.com/dlegs/bounty-hunter/storage"
+  "github.com/miekg/dns"
+)
+
+// DNS providers that let anyone create a hosted zone for any name, so a
+// delegation to them without a zone can be claimed, by nameserver pattern.
+var nsProviders = map[string]string{
+  "awsdns-": "route53",
+  ".azure-dns.": "azure-dns",
+  "digitalocean.com": "digitalocean-dns",
+  "googledomains.com": "google-cloud-dns",
+  "nsone.net": "ns1",
+  "dnsimple.com": "dnsimple",
+  "linode.com": "linode-dns",
+  "dns.he.net": "hurricane-electric",
+}
+
+// Mail services that receive mail for a domain once it's verified on an
+// account, by MX pattern.
+var mxProviders = map[string]string{
+  "mailgun.org": "mailgun",
+  "sendgrid.net": "sendgrid",
+  "amazonaws.com": "amazon-ses",
+  "mandrillapp.com": "mandrill",
+  "mtasv.net": "postmark",
+  "sparkpostmail.com": "sparkpost",
+  "zoho.": "zoho",
+}
+
+// IdentifyDelegation checks the NS and MX records of the name for dangling
+// delegations and mail exchangers and stores any found like other takeovers.
+// Takeovers of a kind that no longer match are marked fixed.
+func (c *Client) IdentifyDelegation(ctx context.Context, subdomain *storage.Subdomain, notify bool) []*storage.Takeover {
+  takeovers := []*storage.Takeover{}
+  checks := []struct{
+    kind string
+    check func(context.Context, string) (*storage.Takeover, error)
+  }{
+    {storage.TakeoverNS, c.CheckNS},
+    {storage.TakeoverMX, c.CheckMX},
+  }
+  for _, check := range checks {
+    t, err := check.check(ctx, subdomain.Name)
+    if err != nil {
+      log.Printf("failed to check %s records of %q for takeover: %v", check.kind, subdomain.Name, err)
+      continue
+    }
+    if t == nil {
+      if err := c.db.FixTakeovers(subdomain, check.kind); err != nil {
+        log.Printf("failed to mark %s takeovers of %q fixed: %v", check.kind, subdomain.Name, err)
+      }
+      continue
+    }
+    log.Printf("Possible %s takeover of %q (%s): %s", t.Service, subdomain.Name, t.Confidence, strings.Join(t.Records, ", "))
+    if err := c.db.UpsertTakeover(t); err != nil {
+      log.Printf("failed to store takeover of %q: %v", subdomain.Name, err)
+    }
+    if notify {
+      if err := c.slack.NotifyTakeover(&storage.Subdomain{Name: subdomain.Name, Takeover: t.Service, TakeoverEvidence: t}); err != nil {
+        log.Printf("failed to notify takeover of %q: %v", subdomain.Name, err)
+      }
+    }
+    takeovers = append(takeovers, t)
+  }
+  return takeovers
+}
+
+// CheckNS returns a takeover if the name is delegated to nameservers that all
+// refuse or fail to answer for it, or nil if it isn't delegated or at least one
+// of them serves it.
+func (c *Client) CheckNS(ctx context.Context, name string) (*storage.Takeover, error) {
+  nameservers, err := c.resolver.Delegation(ctx, name)
+  if err != nil || len(nameservers) == 0 {
+    return nil, err
+  }
+  records := []string{}
+  lame := 0
+  service := ""
+  for _, ns := range nameservers {
+    status := c.nameserverStatus(ctx, name, ns)
+    records = append(records, fmt.Sprintf("NS %s: %s", ns, status))
+    if status == "authoritative" {
+      return nil, nil
+    }
+    if status != "timeout" {
+      lame++
+    }
+    if service == "" {
+      service = provider(nsProviders, ns)
+    }
+  }
+  // Timeouts alone are more likely our network than a dangling delegation.
+  if lame == 0 {
+    return nil, nil
+  }
+  if service == "" {
+    service = storage.TakeoverNS
+  }
+  return &storage.Takeover{
+    Subdomain: name,
+    Kind: storage.TakeoverNS,
+    Service: service,
+    Confidence: storage.ConfidenceLameDelegation,
+    Records: records,
+  }, nil
+}
+
+// nameserverStatus asks the nameserver for the SOA of the name and describes
+// the answer: authoritative, the rcode, not authoritative, nxdomain if the
+// nameserver itself doesn't exist, or timeout.
+func (c *Client) nameserverStatus(ctx context.Context, name, ns string) string {
+  ips, err := c.resolver.LookupIP(ctx, ns)
+  if err != nil || len(ips) == 0 {
+    if nx, err := c.resolver.NXDomain(ctx, ns); err == nil && nx {
+      return "nxdomain"
+    }
+    return "unresolvable"
+  }
+  res, err := c.resolver.QueryServer(ctx, name, dns.TypeSOA, net.JoinHostPort(ips[0].String(), "53"))
+  if err != nil {
+    return "timeout"
+  }
+  if res.Rcode != dns.RcodeSuccess {
+    return strings.ToLower(dns.RcodeToString[res.Rcode])
+  }
+  if !res.Authoritative {
+    return "not authoritative"
+  }
+  return "authoritative"
+}
+
+// CheckMX returns a takeover if any mail exchanger of the name doesn't exist,
+// or nil if they all do.
+func (c *Client) CheckMX(ctx context.Context, name string) (*storage.Takeover, error) {
+  exchangers, err := c.resolver.LookupMX(ctx, name)
+  if err != nil || len(exchangers) == 0 {
+    return nil, err
+  }
+  records := []string{}
+  service := ""
+  for _, mx := range exchangers {
+    nx, err := c.resolver.NXDomain(ctx, mx)
+    if err != nil {
+      return nil, err
+    }
+    if !nx {
+      continue
+    }
+    records = append(records, fmt.Sprintf("MX %s: nxdomain", mx))
+    if service == "" {
+      service = provider(mxProviders, mx)
+    }
+  }
+  if len(records) == 0 {
+    return nil, nil
+  }
+  if service == "" {
+    service = storage.TakeoverMX
+  }
+  return &storage.Takeover{
+    Subdomain: name,
+    Kind: storage.TakeoverMX,
+    Service: service,
+    Confidence: storage.ConfidenceMXNXDomain,
+    Records: records,
+  }, nil
+}
+
+// provider returns the provider whose pattern the host contains, if any.
+func provider(providers map[string]string, host string) string {
+  for pattern, name := range providers {
+    if strings.Contains(host, pattern) {
+      return name
+    }
+  }
+  return ""
+}
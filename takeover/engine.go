@@ -0,0 +1,218 @@
+package takeover
+
+import (
+  "bytes"
+  "context"
+  "crypto/tls"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "strings"
+  "time"
+
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// Maximum number of body bytes read from each response.
+const maxBodyBytes = 1 << 20
+
+// Number of bytes kept on each side of a matched fingerprint.
+const snippetContext = 80
+
+// Fingerprint identifies a service vulnerable to takeover. It uses the same
+// schema as subjack's fingerprints.json.
+type Fingerprint struct {
+  Service string `json:"service"`
+  // Substrings of CNAME targets pointing at the service.
+  CNAME []string `json:"cname"`
+  // Substrings of response bodies served for unclaimed resources.
+  Fingerprint []string `json:"fingerprint"`
+  // Whether a CNAME match alone is enough when the name doesn't resolve.
+  NXDomain bool `json:"nxdomain"`
+}
+
+// Result holds a takeover match and the evidence it was based on.
+type Result struct {
+  Service string
+  // One of the storage confidence levels.
+  Confidence string
+  // CNAME chain of the subdomain in order.
+  CNAMEs []string
+  // CNAME target that matched the fingerprint, if any.
+  MatchedCNAME string
+  // Whether the subdomain answered with NXDOMAIN.
+  NXDomain bool
+  // URL whose response matched, if any.
+  URL string
+  StatusCode int
+  Headers http.Header
+  // Fingerprint string found in the body.
+  Fingerprint string
+  // Part of the body surrounding the matched fingerprint.
+  Snippet string
+}
+
+// response is a fetched page.
+type response struct {
+  url string
+  statusCode int
+  headers http.Header
+  body []byte
+}
+
+// Check follows the CNAME chain of the name and fetches it over HTTP and, if
+// enabled, HTTPS to match it against the fingerprints. It returns nil if
+// nothing matched.
+func (c *Client) Check(ctx context.Context, name string) (*Result, error) {
+  chain, err := c.resolver.CNAMEChain(ctx, name)
+  if err != nil {
+    return nil, err
+  }
+  nx, err := c.resolver.NXDomain(ctx, name)
+  if err != nil {
+    return nil, err
+  }
+
+  responses := []*response{}
+  if !nx {
+    schemes := []string{"http"}
+    if c.https {
+      schemes = append(schemes, "https")
+    }
+    for _, scheme := range schemes {
+      if res := c.fetch(ctx, fmt.Sprintf("%s://%s/", scheme, name)); res != nil {
+        responses = append(responses, res)
+      }
+    }
+  }
+
+  return match(c.Fingerprints(), chain, nx, responses), nil
+}
+
+// match returns the strongest match of the fingerprints against a name's CNAME
+// chain, NXDOMAIN status and responses, or nil if none matched.
+func match(fingerprints []*Fingerprint, chain []string, nx bool, responses []*response) *Result {
+  // A CNAME alone is the weakest evidence, so only fall back to it if no
+  // fingerprint matched more strongly.
+  var cnameOnly *Result
+  for _, fp := range fingerprints {
+    matchedCNAME := matchCNAME(chain, fp.CNAME)
+    if nx {
+      if fp.NXDomain && matchedCNAME != "" {
+        return &Result{
+          Service: strings.ToLower(fp.Service),
+          Confidence: storage.ConfidenceNXDomain,
+          CNAMEs: chain,
+          MatchedCNAME: matchedCNAME,
+          NXDomain: true,
+        }
+      }
+      continue
+    }
+    // Generic error pages contain many of the fingerprints, so bodies only
+    // count for names pointing at the service.
+    if matchedCNAME == "" {
+      continue
+    }
+    for _, res := range responses {
+      for _, f := range fp.Fingerprint {
+        i := bytes.Index(res.body, []byte(f))
+        if f == "" || i < 0 {
+          continue
+        }
+        return &Result{
+          Service: strings.ToLower(fp.Service),
+          Confidence: storage.ConfidenceBody,
+          CNAMEs: chain,
+          MatchedCNAME: matchedCNAME,
+          URL: res.url,
+          StatusCode: res.statusCode,
+          Headers: res.headers,
+          Fingerprint: f,
+          Snippet: snippet(res.body, i, len(f)),
+        }
+      }
+    }
+    if cnameOnly == nil {
+      cnameOnly = &Result{
+        Service: strings.ToLower(fp.Service),
+        Confidence: storage.ConfidenceCNAME,
+        CNAMEs: chain,
+        MatchedCNAME: matchedCNAME,
+      }
+    }
+  }
+  return cnameOnly
+}
+
+// Vulnerable reports whether the result is evidence of a takeover rather than
+// only a CNAME pointing to the service.
+func (r *Result) Vulnerable() bool {
+  return r.Confidence != storage.ConfidenceCNAME
+}
+
+// fetch requests the url without following redirects, returning nil on error.
+func (c *Client) fetch(ctx context.Context, url string) *response {
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+  if err != nil {
+    return nil
+  }
+  req.Header.Set("Connection", "close")
+  res, err := c.http.Do(req)
+  if err != nil {
+    return nil
+  }
+  defer res.Body.Close()
+  body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxBodyBytes))
+  if err != nil {
+    return nil
+  }
+  return &response{
+    url: url,
+    statusCode: res.StatusCode,
+    headers: res.Header,
+    body: body,
+  }
+}
+
+// newHTTPClient returns a client that skips certificate verification, since
+// unclaimed resources rarely serve a valid certificate, and doesn't follow
+// redirects.
+func newHTTPClient(timeout time.Duration) *http.Client {
+  return &http.Client{
+    Timeout: timeout,
+    Transport: &http.Transport{
+      TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+      DisableKeepAlives: true,
+    },
+    CheckRedirect: func(req *http.Request, via []*http.Request) error {
+      return http.ErrUseLastResponse
+    },
+  }
+}
+
+// matchCNAME returns the first target in the chain containing any pattern.
+func matchCNAME(chain, patterns []string) string {
+  for _, target := range chain {
+    for _, pattern := range patterns {
+      if pattern != "" && strings.Contains(target, pattern) {
+        return target
+      }
+    }
+  }
+  return ""
+}
+
+// snippet returns the body around a match of length n at index i.
+func snippet(body []byte, i, n int) string {
+  start := i - snippetContext
+  if start < 0 {
+    start = 0
+  }
+  end := i + n + snippetContext
+  if end > len(body) {
+    end = len(body)
+  }
+  return strings.TrimSpace(string(body[start:end]))
+}
@@ -0,0 +1,230 @@
+package takeover
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+// LoadFingerprints reads and validates fingerprints from a JSON file, or from
+// every .json file in a directory.
+func LoadFingerprints(path string) ([]*Fingerprint, error) {
+  files, err := fingerprintFiles(path)
+  if err != nil {
+    return nil, err
+  }
+  fingerprints := []*Fingerprint{}
+  services := map[string]string{}
+  for _, file := range files {
+    config, err := ioutil.ReadFile(file)
+    if err != nil {
+      return nil, fmt.Errorf("failed to read fingerprints file: %v", err)
+    }
+    var fps []*Fingerprint
+    dec := json.NewDecoder(bytes.NewReader(config))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&fps); err != nil {
+      return nil, fmt.Errorf("%s: failed to parse fingerprints json: %v", file, err)
+    }
+    for i, fp := range fps {
+      if err := validate(fp); err != nil {
+        return nil, fmt.Errorf("%s: fingerprint %d (service %q): %v", file, i, fp.Service, err)
+      }
+      service := strings.ToLower(fp.Service)
+      if other, ok := services[service]; ok {
+        return nil, fmt.Errorf("%s: fingerprint %d: service %q is already defined in %s", file, i, fp.Service, other)
+      }
+      services[service] = file
+      fingerprints = append(fingerprints, fp)
+    }
+  }
+  if len(fingerprints) == 0 {
+    return nil, fmt.Errorf("no fingerprints found in %s", path)
+  }
+  return fingerprints, nil
+}
+
+// validate checks a fingerprint can ever match.
+func validate(fp *Fingerprint) error {
+  if fp == nil {
+    return fmt.Errorf("fingerprint is null")
+  }
+  if strings.TrimSpace(fp.Service) == "" {
+    return fmt.Errorf("service is empty")
+  }
+  // Fingerprints only apply to names CNAMEd to the service.
+  if len(fp.CNAME) == 0 {
+    return fmt.Errorf("needs at least one cname")
+  }
+  for i, cname := range fp.CNAME {
+    if strings.TrimSpace(cname) == "" {
+      return fmt.Errorf("cname %d is empty", i)
+    }
+  }
+  for i, f := range fp.Fingerprint {
+    if strings.TrimSpace(f) == "" {
+      return fmt.Errorf("fingerprint %d is empty, which would match every response", i)
+    }
+  }
+  return nil
+}
+
+// fingerprintFiles returns the path if it's a file, or the .json files in it
+// if it's a directory.
+func fingerprintFiles(path string) ([]string, error) {
+  info, err := os.Stat(path)
+  if err != nil {
+    return nil, fmt.Errorf("failed to stat fingerprints: %v", err)
+  }
+  if !info.IsDir() {
+    return []string{path}, nil
+  }
+  files, err := filepath.Glob(filepath.Join(path, "*.json"))
+  if err != nil {
+    return nil, fmt.Errorf("failed to list fingerprints directory: %v", err)
+  }
+  sort.Strings(files)
+  return files, nil
+}
+
+// modTime returns the latest modification time of the fingerprint files, so
+// edits, additions and removals are all noticed.
+func modTime(path string) (time.Time, error) {
+  info, err := os.Stat(path)
+  if err != nil {
+    return time.Time{}, err
+  }
+  latest := info.ModTime()
+  files, err := fingerprintFiles(path)
+  if err != nil {
+    return time.Time{}, err
+  }
+  for _, file := range files {
+    info, err := os.Stat(file)
+    if err != nil {
+      return time.Time{}, err
+    }
+    if info.ModTime().After(latest) {
+      latest = info.ModTime()
+    }
+  }
+  return latest, nil
+}
+
+// Reload rereads the fingerprints. The current fingerprints are kept if the
+// new ones fail to load or validate.
+func (c *Client) Reload() error {
+  fingerprints, err := LoadFingerprints(c.fingerprintsPath)
+  if err != nil {
+    return err
+  }
+  c.mu.Lock()
+  c.fingerprints = fingerprints
+  c.mu.Unlock()
+  log.Printf("Loaded %d takeover fingerprints from %s", len(fingerprints), c.fingerprintsPath)
+  return nil
+}
+
+// Watch polls the fingerprints file or directory and reloads it on changes
+// until ctx is done.
+func (c *Client) Watch(ctx context.Context, interval time.Duration) {
+  last, _ := modTime(c.fingerprintsPath)
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+    }
+    mod, err := modTime(c.fingerprintsPath)
+    if err != nil || !mod.After(last) {
+      continue
+    }
+    last = mod
+    if err := c.Reload(); err != nil {
+      log.Printf("failed to reload fingerprints, keeping the previous ones: %v", err)
+    }
+  }
+}
+
+// Sample is a recorded response and the service it should be identified as.
+type Sample struct {
+  // Name of the sample, defaults to its file name.
+  Name string `json:"name"`
+  CNAMEs []string `json:"cnames"`
+  NXDomain bool `json:"nxdomain"`
+  StatusCode int `json:"status_code"`
+  Body string `json:"body"`
+  // File holding the body, relative to the sample.
+  BodyFile string `json:"body_file"`
+  // Service the sample must match, or empty if it must not match anything.
+  Expect string `json:"expect"`
+  // Confidence the match must have, any if empty.
+  ExpectConfidence string `json:"expect_confidence"`
+}
+
+// SampleResult is the outcome of running the fingerprints against a sample.
+type SampleResult struct {
+  Sample *Sample
+  File string
+  Result *Result
+  Passed bool
+}
+
+// TestSamples runs the fingerprints against every .json sample in dir.
+func TestSamples(fingerprints []*Fingerprint, dir string) ([]*SampleResult, error) {
+  files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+  if err != nil {
+    return nil, fmt.Errorf("failed to list samples: %v", err)
+  }
+  sort.Strings(files)
+  results := []*SampleResult{}
+  for _, file := range files {
+    config, err := ioutil.ReadFile(file)
+    if err != nil {
+      return nil, fmt.Errorf("failed to read sample: %v", err)
+    }
+    sample := &Sample{}
+    if err := json.Unmarshal(config, sample); err != nil {
+      return nil, fmt.Errorf("%s: failed to parse sample json: %v", file, err)
+    }
+    if sample.Name == "" {
+      sample.Name = strings.TrimSuffix(filepath.Base(file), ".json")
+    }
+    body := []byte(sample.Body)
+    if sample.BodyFile != "" {
+      if body, err = ioutil.ReadFile(filepath.Join(filepath.Dir(file), sample.BodyFile)); err != nil {
+        return nil, fmt.Errorf("%s: failed to read body file: %v", file, err)
+      }
+    }
+    responses := []*response{}
+    if !sample.NXDomain {
+      responses = append(responses, &response{
+        url: "http://" + sample.Name + "/",
+        statusCode: sample.StatusCode,
+        body: body,
+      })
+    }
+    result := match(fingerprints, sample.CNAMEs, sample.NXDomain, responses)
+    passed := result == nil && sample.Expect == ""
+    if result != nil {
+      passed = result.Service == strings.ToLower(sample.Expect) && (sample.ExpectConfidence == "" || result.Confidence == sample.ExpectConfidence)
+    }
+    results = append(results, &SampleResult{
+      Sample: sample,
+      File: file,
+      Result: result,
+      Passed: passed,
+    })
+  }
+  return results, nil
+}
@@ -0,0 +1,112 @@
+package takeover
+
+import (
+  "io/ioutil"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestSamplesPass(t *testing.T) {
+  fingerprints, err := LoadFingerprints("../fingerprints.json")
+  if err != nil {
+    t.Fatalf("LoadFingerprints() error = %v", err)
+  }
+  results, err := TestSamples(fingerprints, "../samples/takeover")
+  if err != nil {
+    t.Fatalf("TestSamples() error = %v", err)
+  }
+  if len(results) == 0 {
+    t.Fatal("TestSamples() found no samples")
+  }
+  for _, r := range results {
+    if !r.Passed {
+      got := ""
+      if r.Result != nil {
+        got = r.Result.Service + " " + r.Result.Confidence
+      }
+      t.Errorf("sample %s: got %q, want %q %s", r.Sample.Name, got, r.Sample.Expect, r.Sample.ExpectConfidence)
+    }
+  }
+}
+
+func TestLoadFingerprints(t *testing.T) {
+  tests := []struct {
+    name string
+    // Files written to the fingerprints directory.
+    files map[string]string
+    // Substring of the error, or empty if loading must succeed.
+    wantErr string
+    wantCount int
+  }{
+    {
+      name: "valid",
+      files: map[string]string{
+        "a.json": `[{"service": "s3", "cname": ["amazonaws"], "fingerprint": ["NoSuchBucket"]}]`,
+        "b.json": `[{"service": "azure", "cname": ["azurewebsites.net"], "nxdomain": true}]`,
+      },
+      wantCount: 2,
+    },
+    {
+      name: "empty service",
+      files: map[string]string{"a.json": `[{"service": " ", "cname": ["amazonaws"]}]`},
+      wantErr: "service is empty",
+    },
+    {
+      name: "nothing to match",
+      files: map[string]string{"a.json": `[{"service": "s3"}]`},
+      wantErr: "needs at least one cname",
+    },
+    {
+      name: "body without cname",
+      files: map[string]string{"a.json": `[{"service": "s3", "fingerprint": ["NoSuchBucket"]}]`},
+      wantErr: "needs at least one cname",
+    },
+    {
+      name: "empty fingerprint",
+      files: map[string]string{"a.json": `[{"service": "s3", "cname": ["amazonaws"], "fingerprint": [""]}]`},
+      wantErr: "would match every response",
+    },
+    {
+      name: "unknown field",
+      files: map[string]string{"a.json": `[{"service": "s3", "cnames": ["amazonaws"]}]`},
+      wantErr: "unknown field",
+    },
+    {
+      name: "duplicate service across files",
+      files: map[string]string{
+        "a.json": `[{"service": "s3", "cname": ["amazonaws"]}]`,
+        "b.json": `[{"service": "S3", "cname": ["s3.amazonaws.com"]}]`,
+      },
+      wantErr: "already defined",
+    },
+    {
+      name: "empty directory",
+      files: map[string]string{},
+      wantErr: "no fingerprints found",
+    },
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      dir := t.TempDir()
+      for name, content := range tt.files {
+        if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+          t.Fatalf("failed to write %s: %v", name, err)
+        }
+      }
+      fingerprints, err := LoadFingerprints(dir)
+      if tt.wantErr != "" {
+        if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+          t.Fatalf("LoadFingerprints() error = %v, want error containing %q", err, tt.wantErr)
+        }
+        return
+      }
+      if err != nil {
+        t.Fatalf("LoadFingerprints() error = %v", err)
+      }
+      if len(fingerprints) != tt.wantCount {
+        t.Errorf("LoadFingerprints() loaded %d fingerprints, want %d", len(fingerprints), tt.wantCount)
+      }
+    })
+  }
+}
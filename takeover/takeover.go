@@ -1,14 +1,19 @@
-// Package takeover uses subjack to check for subdomain takeovers.
+// Package takeover matches subdomains against service fingerprints to check for
+// subdomain takeovers.
 package takeover
 
 import (
+  "context"
   "fmt"
-  "encoding/json"
-  "io/ioutil"
+  "log"
+  "net/http"
+  "sort"
   "strings"
+  "sync"
+  "time"
 
-  "github.com/haccer/subjack/subjack"
   "github.com/dlegs/bounty-hunter/notify"
+  "github.com/dlegs/bounty-hunter/resolver"
   "github.com/dlegs/bounty-hunter/storage"
 )
 
@@ -16,42 +21,108 @@ import (
 type Client struct {
   db *storage.Client
   slack *notify.Client
-  fingerprints []subjack.Fingerprints
+  resolver *resolver.Client
+  http *http.Client
+  // Whether to also fetch subdomains over HTTPS.
+  https bool
+  // File or directory the fingerprints are loaded from.
+  fingerprintsPath string
+  // Guards fingerprints, which are swapped on reload.
+  mu sync.RWMutex
+  fingerprints []*Fingerprint
 }
 
-// New returns a new subjack client.
-func New(db *storage.Client, slack *notify.Client, fingerprintsFile string) (*Client, error) {
-  var fingerprints []subjack.Fingerprints
-  config, err := ioutil.ReadFile(fingerprintsFile)
+// New returns a new takeover client with fingerprints read from
+// fingerprintsPath, either a file or a directory of files.
+func New(db *storage.Client, slack *notify.Client, resolver *resolver.Client, fingerprintsPath string, timeout time.Duration, https bool) (*Client, error) {
+  fingerprints, err := LoadFingerprints(fingerprintsPath)
   if err != nil {
-    return nil, fmt.Errorf("failed to read subjack fingerprints file: %v", err)
-  }
-  if err = json.Unmarshal(config, &fingerprints); err != nil {
-    return nil, fmt.Errorf("failed to parse fingerprints json: %v")
+    return nil, err
   }
   return &Client{
     db: db,
     slack: slack,
+    resolver: resolver,
+    http: newHTTPClient(timeout),
+    https: https,
+    fingerprintsPath: fingerprintsPath,
     fingerprints: fingerprints,
   }, nil
 }
 
-// Identify checks to see if a subdomain takeover is available.
-func(c *Client) Identify(subdomain *storage.Subdomain, rescan bool, takeoverc chan string) {
-  service := subjack.Identify(subdomain.Name, false, false, 10, c.fingerprints)
-  if service != "" {
-    subdomain.Takeover = strings.ToLower(service)
+// Fingerprints returns the loaded fingerprints.
+func (c *Client) Fingerprints() []*Fingerprint {
+  c.mu.RLock()
+  defer c.mu.RUnlock()
+  return c.fingerprints
+}
+
+// Identify checks to see if a subdomain takeover is available through its
+// CNAME, NS or MX records and stores the evidence of any match.
+func(c *Client) Identify(ctx context.Context, subdomain *storage.Subdomain, rescan bool, takeoverc chan string) {
+  result, err := c.Check(ctx, subdomain.Name)
+  if err != nil {
+    log.Printf("failed to check %q for takeover: %v", subdomain.Name, err)
+  }
+  if result != nil && !result.Vulnerable() {
+    // Claimed sites CNAMEd to a service match the same way, so a CNAME alone
+    // is stored for review but neither labelled nor alerted. The row stays
+    // open rather than being marked fixed.
+    log.Printf("CNAME of %q points to %s (%s) but no fingerprint matched: %s", subdomain.Name, result.Service, result.Confidence, strings.Join(result.CNAMEs, " -> "))
+    subdomain.Takeover = ""
+    if err := c.db.UpsertTakeover(evidence(subdomain, result)); err != nil {
+      log.Printf("failed to store takeover of %q: %v", subdomain.Name, err)
+    }
+  } else if result != nil {
+    log.Printf("Possible %s takeover of %q (%s): cname %q, %s [%d] matched %q", result.Service, subdomain.Name, result.Confidence, strings.Join(result.CNAMEs, " -> "), result.URL, result.StatusCode, result.Fingerprint)
+    subdomain.Takeover = result.Service
+    subdomain.TakeoverEvidence = evidence(subdomain, result)
+    if err := c.db.UpsertTakeover(subdomain.TakeoverEvidence); err != nil {
+      log.Printf("failed to store takeover of %q: %v", subdomain.Name, err)
+    }
     // If subdomain exists, notify that a new takeover has been found.
     if rescan {
       if err := c.slack.NotifyTakeover(subdomain); err != nil {
-        return
+        log.Printf("failed to notify takeover of %q: %v", subdomain.Name, err)
       }
     }
   } else {
     subdomain.Takeover = ""
+    if err == nil {
+      if err := c.db.FixTakeovers(subdomain, storage.TakeoverCNAME); err != nil {
+        log.Printf("failed to mark takeovers of %q fixed: %v", subdomain.Name, err)
+      }
+    }
+  }
+  if delegations := c.IdentifyDelegation(ctx, subdomain, rescan); len(delegations) > 0 && subdomain.Takeover == "" {
+    subdomain.Takeover = delegations[0].Service
+    subdomain.TakeoverEvidence = delegations[0]
   }
   if err := c.db.InsertSubdomain(subdomain); err != nil {
-    return
+    log.Printf("failed to insert subdomain %q: %v", subdomain.Name, err)
+  }
+  takeoverc <- subdomain.Takeover
+}
+
+// evidence converts a match into its stored form.
+func evidence(subdomain *storage.Subdomain, result *Result) *storage.Takeover {
+  headers := []string{}
+  for key, values := range result.Headers {
+    for _, value := range values {
+      headers = append(headers, fmt.Sprintf("%s: %s", key, value))
+    }
+  }
+  sort.Strings(headers)
+  return &storage.Takeover{
+    Subdomain: subdomain.Name,
+    Kind: storage.TakeoverCNAME,
+    Service: result.Service,
+    Confidence: result.Confidence,
+    CNAMEs: result.CNAMEs,
+    URL: result.URL,
+    StatusCode: result.StatusCode,
+    Headers: strings.Join(headers, "\n"),
+    Fingerprint: result.Fingerprint,
+    Excerpt: result.Snippet,
   }
-  takeoverc <- strings.ToLower(service)
 }
@@ -0,0 +1,214 @@
+package tech
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "os"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+// Confidence of patterns that don't set one.
+const defaultConfidence = 100
+
+// Technology is a rule detecting a single technology.
+type Technology struct {
+  Name string
+  Categories []string
+  Headers map[string][]*Pattern
+  Cookies map[string][]*Pattern
+  Meta map[string][]*Pattern
+  HTML []*Pattern
+  ScriptSrc []*Pattern
+  // Technologies this one implies, e.g. WordPress implies PHP.
+  Implies []string
+}
+
+// Pattern is a Wappalyzer pattern: a regex followed by optional \;version:
+// and \;confidence: tags.
+type Pattern struct {
+  Regex *regexp.Regexp
+  // Version template referring to submatches e.g. \1, if any.
+  Version string
+  Confidence int
+}
+
+// Rules holds the technologies of a rules file.
+type Rules []*Technology
+
+// rulesFile holds the fields of the Wappalyzer technologies format, which
+// either nests them under technologies (or apps in older versions) or, as in
+// the split files of the Wappalyzer repo, has them at the top level.
+type rulesFile struct {
+  Technologies map[string]json.RawMessage `json:"technologies"`
+  Apps map[string]json.RawMessage `json:"apps"`
+  Categories map[string]struct {
+    Name string `json:"name"`
+  } `json:"categories"`
+}
+
+// technology holds the fields of a single technology used for detection.
+type technology struct {
+  Cats []int `json:"cats"`
+  Headers map[string]json.RawMessage `json:"headers"`
+  Cookies map[string]json.RawMessage `json:"cookies"`
+  Meta map[string]json.RawMessage `json:"meta"`
+  HTML json.RawMessage `json:"html"`
+  ScriptSrc json.RawMessage `json:"scriptSrc"`
+  // Older name of scriptSrc.
+  Script json.RawMessage `json:"script"`
+  Implies json.RawMessage `json:"implies"`
+}
+
+// LoadRules reads a Wappalyzer technologies file, or every JSON file in a
+// directory of them. Patterns using regex features Go doesn't support, such as
+// lookaheads, are skipped.
+func LoadRules(path string) (Rules, error) {
+  info, err := os.Stat(path)
+  if err != nil {
+    return nil, fmt.Errorf("failed to read tech rules: %v", err)
+  }
+  files := []string{path}
+  if info.IsDir() {
+    if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+      return nil, fmt.Errorf("failed to list tech rule files: %v", err)
+    }
+  }
+  // Categories may come from a separate categories.json.
+  categories := map[string]string{}
+  raw := map[string]json.RawMessage{}
+  for _, file := range files {
+    data, err := ioutil.ReadFile(file)
+    if err != nil {
+      return nil, fmt.Errorf("failed to read tech rules: %v", err)
+    }
+    f := &rulesFile{}
+    if err := json.Unmarshal(data, f); err != nil {
+      return nil, fmt.Errorf("%s: failed to parse tech rules json: %v", file, err)
+    }
+    if filepath.Base(file) == "categories.json" {
+      if err := json.Unmarshal(data, &f.Categories); err != nil {
+        return nil, fmt.Errorf("%s: failed to parse categories: %v", file, err)
+      }
+    }
+    for id, category := range f.Categories {
+      categories[id] = category.Name
+    }
+    technologies := f.Technologies
+    if technologies == nil {
+      technologies = f.Apps
+    }
+    if technologies == nil && f.Categories == nil {
+      if err := json.Unmarshal(data, &technologies); err != nil {
+        return nil, fmt.Errorf("%s: failed to parse tech rules json: %v", file, err)
+      }
+    }
+    for name, t := range technologies {
+      raw[name] = t
+    }
+  }
+
+  rules := Rules{}
+  skipped := 0
+  for name, data := range raw {
+    t := &technology{}
+    if err := json.Unmarshal(data, t); err != nil {
+      return nil, fmt.Errorf("technology %q: %v", name, err)
+    }
+    tech := &Technology{
+      Name: name,
+      Headers: map[string][]*Pattern{},
+      Cookies: map[string][]*Pattern{},
+      Meta: map[string][]*Pattern{},
+    }
+    for _, id := range t.Cats {
+      if category, ok := categories[strconv.Itoa(id)]; ok {
+        tech.Categories = append(tech.Categories, category)
+      }
+    }
+    parse := func(raw json.RawMessage) []*Pattern {
+      patterns := []*Pattern{}
+      for _, s := range stringList(raw) {
+        p, err := parsePattern(s)
+        if err != nil {
+          skipped++
+          continue
+        }
+        patterns = append(patterns, p)
+      }
+      return patterns
+    }
+    // Header, cookie and meta names are case-insensitive.
+    for key, value := range t.Headers {
+      tech.Headers[strings.ToLower(key)] = parse(value)
+    }
+    for key, value := range t.Cookies {
+      tech.Cookies[strings.ToLower(key)] = parse(value)
+    }
+    for key, value := range t.Meta {
+      tech.Meta[strings.ToLower(key)] = parse(value)
+    }
+    tech.HTML = parse(t.HTML)
+    tech.ScriptSrc = append(parse(t.ScriptSrc), parse(t.Script)...)
+    for _, implied := range stringList(t.Implies) {
+      tech.Implies = append(tech.Implies, strings.SplitN(implied, "\\;", 2)[0])
+    }
+    rules = append(rules, tech)
+  }
+  if len(rules) == 0 {
+    return nil, fmt.Errorf("no technologies found in %s", path)
+  }
+  if skipped > 0 {
+    log.Printf("Skipped %d tech patterns Go can't compile", skipped)
+  }
+  sort.Slice(rules, func(i, j int) bool {
+    return rules[i].Name < rules[j].Name
+  })
+  return rules, nil
+}
+
+// parsePattern parses a pattern and its tags.
+func parsePattern(s string) (*Pattern, error) {
+  parts := strings.Split(s, "\\;")
+  re, err := regexp.Compile("(?i)" + parts[0])
+  if err != nil {
+    return nil, err
+  }
+  p := &Pattern{Regex: re, Confidence: defaultConfidence}
+  for _, tag := range parts[1:] {
+    kv := strings.SplitN(tag, ":", 2)
+    if len(kv) != 2 {
+      continue
+    }
+    switch kv[0] {
+    case "version":
+      p.Version = kv[1]
+    case "confidence":
+      if confidence, err := strconv.Atoi(kv[1]); err == nil {
+        p.Confidence = confidence
+      }
+    }
+  }
+  return p, nil
+}
+
+// stringList decodes a field that is either a string or a list of strings.
+func stringList(raw json.RawMessage) []string {
+  if len(raw) == 0 {
+    return nil
+  }
+  var s string
+  if err := json.Unmarshal(raw, &s); err == nil {
+    return []string{s}
+  }
+  var list []string
+  if err := json.Unmarshal(raw, &list); err == nil {
+    return list
+  }
+  return nil
+}
@@ -0,0 +1,231 @@
+// Package tech detects the technologies web servers run, such as Jenkins,
+// Grafana or WordPress, from their HTTP responses using Wappalyzer rules.
+package tech
+
+import (
+  "regexp"
+  "sort"
+  "strings"
+
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+var (
+  scriptRegex = regexp.MustCompile(`(?is)<script[^>]+src\s*=\s*["']?([^"'\s>]+)`)
+  metaRegex = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+  attrRegex = regexp.MustCompile(`(?is)(name|property|content)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+  versionRegex = regexp.MustCompile(`\\(\d)`)
+)
+
+// Client holds the db dependency and the rules.
+type Client struct {
+  db *storage.Client
+  rules Rules
+}
+
+// New returns a new tech detection client.
+func New(db *storage.Client, rules Rules) *Client {
+  return &Client{
+    db: db,
+    rules: rules,
+  }
+}
+
+// Detect runs the rules against the HTTP response of each probed port of the
+// subdomain and stores the technologies found.
+func (c *Client) Detect(subdomain *storage.Subdomain) error {
+  for _, port := range subdomain.Ports {
+    if port.HTTP == nil {
+      continue
+    }
+    port.Technologies = c.rules.Detect(port.HTTP)
+    if err := c.db.ReplaceTechnologies(port, port.Technologies); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// response holds the parts of an HTTP response patterns match against.
+type response struct {
+  headers map[string][]string
+  cookies map[string][]string
+  meta map[string][]string
+  html string
+  scripts []string
+}
+
+// Detect returns the technologies the rules find in the response, including
+// those they imply, sorted by name.
+func (r Rules) Detect(res *storage.HTTPResponse) []*storage.Technology {
+  parsed := parse(res)
+  found := map[string]*storage.Technology{}
+  byName := map[string]*Technology{}
+  for _, t := range r {
+    byName[t.Name] = t
+    version, confidence := t.match(parsed)
+    if confidence == 0 {
+      continue
+    }
+    if confidence > 100 {
+      confidence = 100
+    }
+    found[t.Name] = &storage.Technology{
+      Subdomain: res.Subdomain,
+      Port: res.Port,
+      Name: t.Name,
+      Version: version,
+      Categories: t.Categories,
+      Confidence: confidence,
+    }
+  }
+
+  // Add implied technologies, which may imply others in turn.
+  queue := []string{}
+  for name := range found {
+    queue = append(queue, name)
+  }
+  for len(queue) > 0 {
+    name := queue[0]
+    queue = queue[1:]
+    t, ok := byName[name]
+    if !ok {
+      continue
+    }
+    for _, implied := range t.Implies {
+      if _, ok := found[implied]; ok {
+        continue
+      }
+      tech := &storage.Technology{
+        Subdomain: res.Subdomain,
+        Port: res.Port,
+        Name: implied,
+        Confidence: found[name].Confidence,
+      }
+      if rule, ok := byName[implied]; ok {
+        tech.Categories = rule.Categories
+      }
+      found[implied] = tech
+      queue = append(queue, implied)
+    }
+  }
+
+  technologies := []*storage.Technology{}
+  for _, t := range found {
+    technologies = append(technologies, t)
+  }
+  sort.Slice(technologies, func(i, j int) bool {
+    return technologies[i].Name < technologies[j].Name
+  })
+  return technologies
+}
+
+// match returns the version and summed confidence of the technology's
+// patterns matching the response, 0 if none match.
+func (t *Technology) match(res *response) (string, int) {
+  version := ""
+  confidence := 0
+  try := func(patterns []*Pattern, values []string) {
+    for _, p := range patterns {
+      for _, value := range values {
+        match := p.Regex.FindStringSubmatch(value)
+        if match == nil {
+          continue
+        }
+        confidence += p.Confidence
+        if v := formatVersion(p.Version, match); len(v) > len(version) {
+          version = v
+        }
+        break
+      }
+    }
+  }
+  for name, patterns := range t.Headers {
+    if values, ok := res.headers[name]; ok {
+      try(patterns, values)
+    }
+  }
+  for name, patterns := range t.Cookies {
+    if values, ok := res.cookies[name]; ok {
+      try(patterns, values)
+    }
+  }
+  for name, patterns := range t.Meta {
+    if values, ok := res.meta[name]; ok {
+      try(patterns, values)
+    }
+  }
+  try(t.HTML, []string{res.html})
+  try(t.ScriptSrc, res.scripts)
+  return version, confidence
+}
+
+// formatVersion fills the submatches into a version template such as \1 or
+// the ternary \1?v2:v1, which picks v2 if the submatch is set.
+func formatVersion(template string, match []string) string {
+  if template == "" {
+    return ""
+  }
+  submatch := func(ref string) string {
+    i := int(ref[1] - '0')
+    if i < len(match) {
+      return match[i]
+    }
+    return ""
+  }
+  if parts := strings.SplitN(template, "?", 2); len(parts) == 2 && versionRegex.MatchString(parts[0]) {
+    options := strings.SplitN(parts[1], ":", 2)
+    if len(options) == 2 {
+      if submatch(parts[0]) != "" {
+        template = options[0]
+      } else {
+        template = options[1]
+      }
+    }
+  }
+  return strings.TrimSpace(versionRegex.ReplaceAllStringFunc(template, submatch))
+}
+
+// parse splits a response into the parts patterns match against.
+func parse(res *storage.HTTPResponse) *response {
+  parsed := &response{
+    headers: map[string][]string{},
+    cookies: map[string][]string{},
+    meta: map[string][]string{},
+    html: string(res.Body),
+  }
+  for _, line := range strings.Split(res.Headers, "\n") {
+    kv := strings.SplitN(line, ":", 2)
+    if len(kv) != 2 {
+      continue
+    }
+    name, value := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+    parsed.headers[name] = append(parsed.headers[name], value)
+    if name == "set-cookie" {
+      cookie := strings.SplitN(strings.SplitN(value, ";", 2)[0], "=", 2)
+      if len(cookie) == 2 {
+        cookieName := strings.ToLower(strings.TrimSpace(cookie[0]))
+        parsed.cookies[cookieName] = append(parsed.cookies[cookieName], cookie[1])
+      }
+    }
+  }
+  for _, match := range scriptRegex.FindAllStringSubmatch(parsed.html, -1) {
+    parsed.scripts = append(parsed.scripts, match[1])
+  }
+  for _, tag := range metaRegex.FindAllString(parsed.html, -1) {
+    name, content := "", ""
+    for _, attr := range attrRegex.FindAllStringSubmatch(tag, -1) {
+      value := attr[2] + attr[3]
+      switch strings.ToLower(attr[1]) {
+      case "name", "property":
+        name = strings.ToLower(value)
+      case "content":
+        content = value
+      }
+    }
+    if name != "" {
+      parsed.meta[name] = append(parsed.meta[name], content)
+    }
+  }
+  return parsed
+}
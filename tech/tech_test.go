@@ -0,0 +1,111 @@
+package tech
+
+import (
+  "reflect"
+  "testing"
+
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+func TestParsePattern(t *testing.T) {
+  tests := []struct {
+    pattern string
+    regex string
+    version string
+    confidence int
+    wantErr bool
+  }{
+    {pattern: `^nginx`, regex: `(?i)^nginx`, confidence: 100},
+    {pattern: `nginx(?:/([\d.]+))?\;version:\1`, regex: `(?i)nginx(?:/([\d.]+))?`, version: `\1`, confidence: 100},
+    {pattern: `^authenticity_token$\;confidence:50`, regex: `(?i)^authenticity_token$`, confidence: 50},
+    {pattern: `jquery\;version:\1?\1:old\;confidence:25`, regex: `(?i)jquery`, version: `\1?\1:old`, confidence: 25},
+    {pattern: `x\;confidence:high`, regex: `(?i)x`, confidence: 100},
+    {pattern: `x\;unknown:tag\;bare`, regex: `(?i)x`, confidence: 100},
+    {pattern: ``, regex: `(?i)`, confidence: 100},
+    {pattern: `foo(?!bar)`, wantErr: true},
+  }
+  for _, tt := range tests {
+    p, err := parsePattern(tt.pattern)
+    if (err != nil) != tt.wantErr {
+      t.Errorf("parsePattern(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+      continue
+    }
+    if tt.wantErr {
+      continue
+    }
+    if p.Regex.String() != tt.regex || p.Version != tt.version || p.Confidence != tt.confidence {
+      t.Errorf("parsePattern(%q) = {%q %q %d}, want {%q %q %d}", tt.pattern, p.Regex, p.Version, p.Confidence, tt.regex, tt.version, tt.confidence)
+    }
+  }
+}
+
+func TestFormatVersion(t *testing.T) {
+  tests := []struct {
+    template string
+    match []string
+    want string
+  }{
+    {template: ``, match: []string{"nginx/1.18.0", "1.18.0"}, want: ""},
+    {template: `\1`, match: []string{"nginx/1.18.0", "1.18.0"}, want: "1.18.0"},
+    {template: `\1.\2`, match: []string{"v4.2", "4", "2"}, want: "4.2"},
+    {template: `\1`, match: []string{"nginx", ""}, want: ""},
+    {template: `\3`, match: []string{"nginx/1.18.0", "1.18.0"}, want: ""},
+    {template: `\1?2.x:1.x`, match: []string{"new", "new"}, want: "2.x"},
+    {template: `\1?2.x:1.x`, match: []string{"old", ""}, want: "1.x"},
+    {template: `\1?\1:legacy`, match: []string{"v3", "3"}, want: "3"},
+    {template: `2.0`, match: []string{"x"}, want: "2.0"},
+  }
+  for _, tt := range tests {
+    if got := formatVersion(tt.template, tt.match); got != tt.want {
+      t.Errorf("formatVersion(%q, %q) = %q, want %q", tt.template, tt.match, got, tt.want)
+    }
+  }
+}
+
+func TestDetect(t *testing.T) {
+  rules, err := LoadRules("../technologies.json")
+  if err != nil {
+    t.Fatalf("LoadRules() error = %v", err)
+  }
+  tests := []struct {
+    name string
+    response *storage.HTTPResponse
+    // Names and versions of the technologies found.
+    want map[string]string
+  }{
+    {
+      name: "nothing",
+      response: &storage.HTTPResponse{Headers: "Content-Type: text/html", Body: []byte("<html>hi</html>")},
+      want: map[string]string{},
+    },
+    {
+      name: "server header version",
+      response: &storage.HTTPResponse{Headers: "Server: nginx/1.18.0"},
+      want: map[string]string{"Nginx": "1.18.0"},
+    },
+    {
+      name: "implied technologies",
+      response: &storage.HTTPResponse{
+        Headers: "Server: Apache\nX-Pingback: https://blog.example.com/xmlrpc.php",
+        Body: []byte(`<html><head><meta name="generator" content="WordPress 5.5.1"><script src="/wp-includes/js/jquery/jquery.js?ver=1.12.4"></script></head></html>`),
+      },
+      want: map[string]string{"Apache": "", "WordPress": "5.5.1", "PHP": "", "MySQL": "", "jQuery": "1.12.4"},
+    },
+    {
+      name: "cookies",
+      response: &storage.HTTPResponse{Headers: "Set-Cookie: JSESSIONID=abc; Path=/; HttpOnly\nX-Jenkins: 2.263"},
+      want: map[string]string{"Java": "", "Jenkins": "2.263"},
+    },
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      got := map[string]string{}
+      for _, technology := range rules.Detect(tt.response) {
+        got[technology.Name] = technology.Version
+      }
+      if !reflect.DeepEqual(got, tt.want) {
+        t.Errorf("Detect() = %v, want %v", got, tt.want)
+      }
+    })
+  }
+}
@@ -0,0 +1,113 @@
+// Package zonetransfer attempts AXFR zone transfers against the authoritative
+// nameservers of a domain.
+package zonetransfer
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "net"
+  "sort"
+  "strings"
+  "time"
+
+  "github.com/miekg/dns"
+  "github.com/dlegs/bounty-hunter/notify"
+  "github.com/dlegs/bounty-hunter/resolver"
+  "github.com/dlegs/bounty-hunter/storage"
+)
+
+// Client holds db, slack and resolver dependencies.
+type Client struct {
+  db *storage.Client
+  slack *notify.Client
+  resolver *resolver.Client
+  // Port nameservers are contacted on, overridable to point at a local server.
+  port string
+  timeout time.Duration
+}
+
+// New returns a new zone transfer client.
+func New(db *storage.Client, slack *notify.Client, resolver *resolver.Client, port string, timeout time.Duration) *Client {
+  return &Client{
+    db: db,
+    slack: slack,
+    resolver: resolver,
+    port: port,
+    timeout: timeout,
+  }
+}
+
+// Attempt tries a zone transfer of the domain against each of its nameservers.
+// Successful transfers are stored as findings and notified, and the names in
+// the zone are returned.
+func (c *Client) Attempt(ctx context.Context, domain *storage.Domain) ([]string, error) {
+  nameservers, err := c.resolver.LookupNS(ctx, domain.Name)
+  if err != nil {
+    return nil, fmt.Errorf("failed to look up nameservers of %q: %v", domain.Name, err)
+  }
+  seen := map[string]bool{}
+  for _, ns := range nameservers {
+    names, err := c.Transfer(domain.Name, net.JoinHostPort(ns, c.port))
+    if err != nil {
+      log.Printf("Zone transfer of %q from %q refused: %v", domain.Name, ns, err)
+      continue
+    }
+    log.Printf("Zone transfer of %q from %q succeeded with %d names", domain.Name, ns, len(names))
+    finding := &storage.Finding{
+      Target: domain.Name,
+      Kind: "axfr",
+      Severity: storage.SeverityHigh,
+      Detail: fmt.Sprintf("Nameserver %s allows zone transfers, %d names in zone", ns, len(names)),
+    }
+    if err := c.db.InsertFinding(finding); err != nil {
+      return nil, err
+    }
+    if err := c.slack.NotifyFinding(finding); err != nil {
+      return nil, fmt.Errorf("failed to notify zone transfer of %q: %v", domain.Name, err)
+    }
+    for _, name := range names {
+      seen[name] = true
+    }
+  }
+  names := []string{}
+  for name := range seen {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names, nil
+}
+
+// Transfer performs an AXFR of the zone from the server and returns every
+// distinct owner name in the zone, excluding wildcards.
+func (c *Client) Transfer(zone, server string) ([]string, error) {
+  t := &dns.Transfer{
+    DialTimeout: c.timeout,
+    ReadTimeout: c.timeout,
+  }
+  m := new(dns.Msg)
+  m.SetAxfr(dns.Fqdn(zone))
+  envelopes, err := t.In(m, server)
+  if err != nil {
+    return nil, err
+  }
+  seen := map[string]bool{}
+  names := []string{}
+  for envelope := range envelopes {
+    if envelope.Error != nil {
+      return nil, envelope.Error
+    }
+    for _, rr := range envelope.RR {
+      name := strings.ToLower(strings.TrimSuffix(rr.Header().Name, "."))
+      if seen[name] || strings.Contains(name, "*") || !dns.IsSubDomain(zone, name) {
+        continue
+      }
+      seen[name] = true
+      names = append(names, name)
+    }
+  }
+  if len(names) == 0 {
+    return nil, fmt.Errorf("transfer returned no records")
+  }
+  return names, nil
+}
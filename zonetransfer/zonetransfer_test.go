@@ -0,0 +1,96 @@
+package zonetransfer
+
+import (
+  "net"
+  "reflect"
+  "testing"
+  "time"
+
+  "github.com/miekg/dns"
+)
+
+const soa = "example.com. 3600 IN SOA ns1.example.com. admin.example.com. 1 7200 3600 1209600 3600"
+
+// serve starts a nameserver on a local port answering AXFR queries with the
+// records, or refusing them if records is nil, and returns its address.
+func serve(t *testing.T, records []string) string {
+  rrs := []dns.RR{}
+  for _, record := range records {
+    rr, err := dns.NewRR(record)
+    if err != nil {
+      t.Fatalf("failed to parse record %q: %v", record, err)
+    }
+    rrs = append(rrs, rr)
+  }
+  handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+    if records == nil {
+      m := new(dns.Msg)
+      m.SetRcode(req, dns.RcodeRefused)
+      w.WriteMsg(m)
+      return
+    }
+    ch := make(chan *dns.Envelope, 1)
+    ch <- &dns.Envelope{RR: rrs}
+    close(ch)
+    new(dns.Transfer).Out(w, req, ch)
+  })
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatalf("failed to listen: %v", err)
+  }
+  started := make(chan struct{})
+  server := &dns.Server{Listener: listener, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+  go server.ActivateAndServe()
+  <-started
+  t.Cleanup(func() { server.Shutdown() })
+  return listener.Addr().String()
+}
+
+func TestTransfer(t *testing.T) {
+  tests := []struct {
+    name string
+    records []string
+    want []string
+    wantErr bool
+  }{
+    {
+      name: "allowed",
+      records: []string{
+        soa,
+        "example.com. 3600 IN NS ns1.example.com.",
+        "www.example.com. 3600 IN A 192.0.2.1",
+        "WWW.example.com. 3600 IN AAAA 2001:db8::1",
+        "dev.internal.example.com. 3600 IN CNAME www.example.com.",
+        soa,
+      },
+      want: []string{"example.com", "www.example.com", "dev.internal.example.com"},
+    },
+    {
+      name: "wildcards and out of zone names skipped",
+      records: []string{
+        soa,
+        "*.example.com. 3600 IN A 192.0.2.1",
+        "api.example.com. 3600 IN A 192.0.2.2",
+        "glue.example.net. 3600 IN A 192.0.2.3",
+        soa,
+      },
+      want: []string{"example.com", "api.example.com"},
+    },
+    {
+      name: "refused",
+      wantErr: true,
+    },
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      c := &Client{timeout: 2 * time.Second}
+      got, err := c.Transfer("example.com", serve(t, tt.records))
+      if (err != nil) != tt.wantErr {
+        t.Fatalf("Transfer() error = %v, wantErr %v", err, tt.wantErr)
+      }
+      if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+        t.Errorf("Transfer() = %q, want %q", got, tt.want)
+      }
+    })
+  }
+}